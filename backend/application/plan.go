@@ -0,0 +1,137 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/errors"
+)
+
+// Plan lists the changes a sync of a source would apply
+type Plan struct {
+	GitCommit string     `json:"gitCommit"`
+	GitRef    string     `json:"gitRef"`
+	Create    []*JobPlan `json:"create"`
+	Update    []*JobPlan `json:"update"`
+	Delete    []*JobPlan `json:"delete"`
+	// jobs that exist in nomad, but have to be adopted first
+	Unmanaged []*JobPlan `json:"unmanaged"`
+}
+
+type JobPlan struct {
+	Name              string          `json:"name"`
+	Namespace         string          `json:"namespace,omitempty"`
+	Diff              json.RawMessage `json:"diff,omitempty"`
+	PlacementFailures json.RawMessage `json:"placementFailures,omitempty"`
+}
+
+var commitRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// IsCommit reports whether the ref is a full commit sha
+func IsCommit(ref string) bool {
+	return commitRegex.MatchString(ref)
+}
+
+// sourceAtRef returns a paused copy of the source pointing to the ref.
+// Tags are given as refs/tags/<tag>, commits as full sha, anything else is a branch
+func sourceAtRef(src *domain.Source, ref string) *domain.Source {
+	cpy := *src
+	cpy.Paused = true
+	cpy.Status = &domain.SourceStatus{}
+	switch {
+	case ref == "":
+	case strings.HasPrefix(ref, "refs/tags/"):
+		cpy.RevisionKind = domain.RevisionKindTag
+		cpy.Branch = strings.TrimPrefix(ref, "refs/tags/")
+	case IsCommit(ref):
+		cpy.RevisionKind = domain.RevisionKindCommit
+		cpy.Branch = ref
+	default:
+		cpy.RevisionKind = domain.RevisionKindBranch
+		cpy.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return &cpy
+}
+
+// PlanSource plans a sync of the watched source at the given ref, or at its own revision if empty, without changing anything
+func (w *RepoWatcher) PlanSource(ctx context.Context, src *domain.Source, ref string) (*Plan, error) {
+	w.lock.Lock()
+	wi, ok := w.watchList[src.ID]
+	w.lock.Unlock()
+	if !ok {
+		return nil, errors.ErrNotFound
+	}
+
+	planSrc := sourceAtRef(src, ref)
+	desiredState, err := w.dsw.FetchDesiredState(ctx, planSrc)
+	if err != nil {
+		w.logger.LogError(ctx, "Could not FetchDesiredState for plan: %v - %v - %v", err, src.URL, ref)
+		return nil, err
+	}
+	if src.VaultTokenID != "" {
+		t, err := w.vaultRepo.GetVaultToken(ctx, src.VaultTokenID)
+		if err != nil {
+			w.logger.LogError(ctx, "Could not GetVaultToken for plan:%v", err)
+			return nil, err
+		}
+		for k := range desiredState.Jobs {
+			desiredState.Jobs[k].VaultToken = &t.Value
+		}
+	}
+	err = w.applyOverrides(ctx, planSrc, desiredState)
+	if err != nil {
+		return nil, err
+	}
+
+	changeInfo, err := wi.Reconciler(ctx, planSrc, desiredState, SyncSourceOptions{DryRun: true})
+	if err != nil {
+		w.logger.LogError(ctx, "Could not plan source %s:%v", src.ID, err)
+		return nil, err
+	}
+
+	plan := &Plan{
+		GitCommit: desiredState.GitInfo.GitCommit,
+		GitRef:    desiredState.GitInfo.GitRef,
+		Create:    jobPlans(planSrc, changeInfo.Create),
+		Update:    jobPlans(planSrc, changeInfo.Update),
+		Delete:    jobPlans(planSrc, changeInfo.Delete),
+		Unmanaged: []*JobPlan{},
+	}
+	for name, jobStatus := range planSrc.Status.Jobs {
+		if jobStatus.Unmanaged {
+			plan.Unmanaged = append(plan.Unmanaged, &JobPlan{
+				Name:      name,
+				Namespace: jobStatus.Namespace,
+				Diff:      jobStatus.Diff,
+			})
+		}
+	}
+	sort.Slice(plan.Unmanaged, func(i, j int) bool {
+		return plan.Unmanaged[i].Name < plan.Unmanaged[j].Name
+	})
+	return plan, nil
+}
+
+// jobPlans lists the jobs sorted by name with the diffs recorded in the status of the planned source
+func jobPlans(src *domain.Source, jobs map[string]*JobInfo) []*JobPlan {
+	res := []*JobPlan{}
+	for name, job := range jobs {
+		p := &JobPlan{
+			Name:      name,
+			Namespace: strPtrToStr(job.Namespace),
+		}
+		if jobStatus, ok := src.Status.Jobs[name]; ok {
+			p.Diff = jobStatus.Diff
+			p.PlacementFailures = jobStatus.PlacementFailures
+		}
+		res = append(res, p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
@@ -0,0 +1,106 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+type fakeDesiredStateWatcher struct {
+	fetched []*domain.Source
+	jobs    []string
+}
+
+func (d *fakeDesiredStateWatcher) FetchDesiredState(ctx context.Context, src *domain.Source) (*DesiredState, error) {
+	d.fetched = append(d.fetched, src)
+	res := &DesiredState{
+		GitInfo: GitInfo{GitCommit: "abc", GitRef: src.Branch},
+		Jobs:    map[string]*JobInfo{},
+	}
+	for _, name := range d.jobs {
+		res.Jobs[name] = testJob(name, "abc")
+	}
+	return res, nil
+}
+
+func (d *fakeDesiredStateWatcher) ReleaseSource(ctx context.Context, id string) error {
+	return nil
+}
+
+func TestSourceAtRef(t *testing.T) {
+	src := &domain.Source{Branch: "main", RevisionKind: domain.RevisionKindBranch}
+	for _, tc := range []struct {
+		ref    string
+		kind   domain.RevisionKind
+		branch string
+	}{
+		{ref: "", kind: domain.RevisionKindBranch, branch: "main"},
+		{ref: "feature/x", kind: domain.RevisionKindBranch, branch: "feature/x"},
+		{ref: "refs/heads/feature/x", kind: domain.RevisionKindBranch, branch: "feature/x"},
+		{ref: "refs/tags/v1.2.0", kind: domain.RevisionKindTag, branch: "v1.2.0"},
+		{ref: "0123456789abcdef0123456789abcdef01234567", kind: domain.RevisionKindCommit, branch: "0123456789abcdef0123456789abcdef01234567"},
+	} {
+		res := sourceAtRef(src, tc.ref)
+		if res.RevisionKind != tc.kind || res.Branch != tc.branch || !res.Paused {
+			t.Errorf("Unexpected source for ref %q: %s %s", tc.ref, res.RevisionKind, res.Branch)
+		}
+	}
+	if src.Paused || src.Branch != "main" {
+		t.Errorf("Expected the source to be unchanged")
+	}
+}
+
+func TestPlanSource(t *testing.T) {
+	ctx := context.Background()
+	cluster := &fakeCluster{
+		jobs: map[string]*JobInfo{
+			"web": testJob("web", "old"),
+			"old": testJob("old", "old"),
+		},
+		updates: map[string]UpdateJobOptions{},
+	}
+	evRepo := &fakeEventRepo{}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		clusterAccess: cluster,
+		evRepo:        evRepo,
+		notifier:      &fakeNotifier{},
+	}
+	dsw := &fakeDesiredStateWatcher{jobs: []string{"web", "api"}}
+	w := &RepoWatcher{
+		logger: log.NewSimpleLogger(false, "Test"),
+		dsw:    dsw,
+		watchList: map[string]*WatchInfo{
+			"src": {Reconciler: r.OnReconcile},
+		},
+	}
+	src := &domain.Source{ID: "src", Branch: "main", Status: &domain.SourceStatus{Status: domain.SourceStatusStatusSynced}}
+
+	plan, err := w.PlanSource(ctx, src, "feature")
+	if err != nil {
+		t.Fatalf("Could not PlanSource:%v", err)
+	}
+	if dsw.fetched[0].Branch != "feature" {
+		t.Fatalf("Expected the ref to be fetched, got %s", dsw.fetched[0].Branch)
+	}
+	if len(plan.Create) != 1 || plan.Create[0].Name != "api" ||
+		len(plan.Update) != 1 || plan.Update[0].Name != "web" ||
+		len(plan.Delete) != 1 || plan.Delete[0].Name != "old" {
+		b, _ := json.Marshal(plan)
+		t.Fatalf("Unexpected plan %s", b)
+	}
+	for name, opts := range cluster.updates {
+		if !opts.DryRun {
+			t.Errorf("Expected job %s to be planned only", name)
+		}
+	}
+	if len(cluster.deleted) != 0 || len(evRepo.events) != 0 {
+		t.Errorf("Expected no changes, got deleted %v and events %v", cluster.deleted, evRepo.events)
+	}
+	if src.Status.Status != domain.SourceStatusStatusSynced || src.Paused {
+		t.Errorf("Expected the watched source to be unchanged")
+	}
+}
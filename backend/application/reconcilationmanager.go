@@ -2,6 +2,9 @@ package application
 
 import (
 	"context"
+	"encoding/json"
+	"sort"
+	"time"
 
 	"github.com/nomad-ops/nomad-ops/backend/domain"
 	"github.com/nomad-ops/nomad-ops/backend/utils/log"
@@ -46,10 +49,27 @@ type KeyRepo interface {
 	GetKey(ctx context.Context, id string) (*domain.DeployKey, error)
 }
 
+type CredentialRepo interface {
+	GetCredential(ctx context.Context, id string) (*domain.Credential, error)
+}
+
+type KnownHostRepo interface {
+	ListKnownHosts(ctx context.Context) ([]*domain.KnownHost, error)
+	SaveKnownHost(ctx context.Context, h *domain.KnownHost) error
+}
+
 type VaultTokenRepo interface {
 	GetVaultToken(ctx context.Context, id string) (*domain.VaultToken, error)
 }
 
+type WebhookSecretRepo interface {
+	GetWebhookSecret(ctx context.Context, id string) (*domain.WebhookSecret, error)
+}
+
+type TeamRepo interface {
+	GetTeam(ctx context.Context, id string) (*domain.Team, error)
+}
+
 type EventRepo interface {
 	SaveEvent(ctx context.Context, ev *domain.Event) error
 }
@@ -57,6 +77,7 @@ type EventRepo interface {
 type SourceWatcher interface {
 	WatchSource(ctx context.Context, src *domain.Source, cb ReconcilerFunc) error
 	StopSourceWatch(ctx context.Context, id string) error
+	RemoveSource(ctx context.Context, id string) error
 }
 
 type ReconciliationManager struct {
@@ -68,9 +89,14 @@ type ReconciliationManager struct {
 	clusterAccess ClusterAPI
 	evRepo        EventRepo
 	notifier      Notifier
+	statusPatcher SourceStatusPatcher
 }
 
 type ReconciliationManagerConfig struct {
+	// pruning more than this percentage of the jobs of a source requires a confirmation, 0 disables the limit
+	MaxPrunePercent int
+	// how long to wait for the deployments of a source that waits for them, 0 waits forever
+	DeploymentTimeout time.Duration
 }
 
 func CreateReconciliationManager(ctx context.Context,
@@ -80,7 +106,8 @@ func CreateReconciliationManager(ctx context.Context,
 	watcher SourceWatcher,
 	clusterAccess ClusterAPI,
 	evRepo EventRepo,
-	notifier Notifier) (*ReconciliationManager, error) {
+	notifier Notifier,
+	statusPatcher SourceStatusPatcher) (*ReconciliationManager, error) {
 	t := &ReconciliationManager{
 		ctx:           ctx,
 		logger:        logger,
@@ -90,6 +117,7 @@ func CreateReconciliationManager(ctx context.Context,
 		clusterAccess: clusterAccess,
 		evRepo:        evRepo,
 		notifier:      notifier,
+		statusPatcher: statusPatcher,
 	}
 
 	// Get all sources from repo on startup
@@ -121,8 +149,135 @@ func (m *ReconciliationManager) ListSources(ctx context.Context, opts ListSource
 	return m.repo.ListSources(ctx, opts)
 }
 
+// OnDeletingSource applies the deletion policy of the source before it is removed.
+// The watch is stopped first, so that no job is registered again, and restarted if the cleanup fails.
+func (m *ReconciliationManager) OnDeletingSource(ctx context.Context, src *domain.Source) error {
+	if src.DeletionPolicy != domain.DeletionPolicyDeregister && src.DeletionPolicy != domain.DeletionPolicyPurge {
+		return nil
+	}
+
+	err := m.watcher.StopSourceWatch(ctx, src.ID)
+	if err != nil {
+		return err
+	}
+
+	err = m.deleteSourceJobs(ctx, src)
+	if err != nil {
+		m.logger.LogError(ctx, "Could not delete jobs of source %s:%v", src.ID, err)
+		watchErr := m.watcher.WatchSource(m.ctx, src, m.OnReconcile)
+		if watchErr != nil {
+			m.logger.LogError(ctx, "Could not WatchSource %s again:%v", src.ID, watchErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *ReconciliationManager) deleteSourceJobs(ctx context.Context, src *domain.Source) error {
+	currentState, err := m.clusterAccess.GetCurrentClusterState(ctx, GetCurrentClusterStateOptions{
+		Source: src,
+	})
+	if err != nil {
+		return err
+	}
+
+	namespaces := map[string]struct{}{}
+	if src.Namespace != "" {
+		namespaces[src.Namespace] = struct{}{}
+	}
+	for k, job := range currentState.CurrentJobs {
+		m.logger.LogInfo(ctx, "Deleting job %s of deleted source %s...", k, src.ID)
+		err := m.clusterAccess.DeleteJob(ctx, src, job, DeleteJobOptions{
+			Purge: src.DeletionPolicy == domain.DeletionPolicyPurge,
+		})
+		if err != nil {
+			return err
+		}
+		if job.Namespace != nil && *job.Namespace != "" {
+			namespaces[*job.Namespace] = struct{}{}
+		}
+	}
+
+	if !src.CreateNamespace {
+		return nil
+	}
+	for ns := range namespaces {
+		// a namespace that is still in use is no reason to keep the source
+		deleted, err := m.clusterAccess.DeleteNamespaceIfEmpty(ctx, src, ns)
+		if err != nil {
+			m.logger.LogError(ctx, "Could not delete namespace %s:%v", ns, err)
+			continue
+		}
+		if deleted {
+			m.logger.LogInfo(ctx, "Deleted empty namespace %s of deleted source %s", ns, src.ID)
+		}
+	}
+	return nil
+}
+
+type UnmanagedJob struct {
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace"`
+	SourceID  string          `json:"sourceId"`
+	Diff      json.RawMessage `json:"diff,omitempty"`
+}
+
+type JobReport struct {
+	// jobs with nomad-ops meta whose source does not exist anymore
+	Orphaned []*ManagedJob `json:"orphaned"`
+	// jobs of a source that exist in nomad, but have to be adopted
+	Unmanaged []*UnmanagedJob `json:"unmanaged"`
+}
+
+// GetJobReport lists jobs that are not managed by a live source
+func (m *ReconciliationManager) GetJobReport(ctx context.Context) (*JobReport, error) {
+	srcs, err := m.repo.ListSources(ctx, ListSourcesOptions{})
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := m.clusterAccess.ListManagedJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &JobReport{
+		Orphaned:  []*ManagedJob{},
+		Unmanaged: []*UnmanagedJob{},
+	}
+	srcIDs := map[string]struct{}{}
+	for _, src := range srcs {
+		srcIDs[src.ID] = struct{}{}
+		if src.Status == nil {
+			continue
+		}
+		for name, job := range src.Status.Jobs {
+			if !job.Unmanaged {
+				continue
+			}
+			report.Unmanaged = append(report.Unmanaged, &UnmanagedJob{
+				Name:      name,
+				Namespace: job.Namespace,
+				SourceID:  src.ID,
+				Diff:      job.Diff,
+			})
+		}
+	}
+	for _, job := range jobs {
+		if _, ok := srcIDs[job.SourceID]; !ok {
+			report.Orphaned = append(report.Orphaned, job)
+		}
+	}
+	sort.Slice(report.Orphaned, func(i, j int) bool {
+		return report.Orphaned[i].Namespace+"/"+report.Orphaned[i].Name < report.Orphaned[j].Namespace+"/"+report.Orphaned[j].Name
+	})
+	sort.Slice(report.Unmanaged, func(i, j int) bool {
+		return report.Unmanaged[i].SourceID+"/"+report.Unmanaged[i].Name < report.Unmanaged[j].SourceID+"/"+report.Unmanaged[j].Name
+	})
+	return report, nil
+}
+
 func (m *ReconciliationManager) OnDeletedSource(ctx context.Context, id string) error {
-	err := m.watcher.StopSourceWatch(ctx, id)
+	err := m.watcher.RemoveSource(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,127 @@
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+type fakeWatcher struct {
+	stopped []string
+}
+
+func (w *fakeWatcher) WatchSource(ctx context.Context, src *domain.Source, cb ReconcilerFunc) error {
+	return nil
+}
+
+func (w *fakeWatcher) StopSourceWatch(ctx context.Context, id string) error {
+	w.stopped = append(w.stopped, id)
+	return nil
+}
+
+func (w *fakeWatcher) RemoveSource(ctx context.Context, id string) error {
+	return nil
+}
+
+func TestOnDeletingSource(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		policy     domain.DeletionPolicy
+		createNs   bool
+		wantDelete int
+		wantPurge  bool
+		wantNs     int
+	}{
+		{policy: domain.DeletionPolicyOrphan},
+		{policy: domain.DeletionPolicyDeregister, wantDelete: 2},
+		{policy: domain.DeletionPolicyPurge, createNs: true, wantDelete: 2, wantPurge: true, wantNs: 1},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			cluster := &fakeCluster{
+				jobs: map[string]*JobInfo{
+					"web": testJob("web", "abc"),
+					"api": testJob("api", "abc"),
+				},
+				updates: map[string]UpdateJobOptions{},
+			}
+			watcher := &fakeWatcher{}
+			m := &ReconciliationManager{
+				ctx:           ctx,
+				logger:        log.NewSimpleLogger(false, "Test"),
+				watcher:       watcher,
+				clusterAccess: cluster,
+				evRepo:        &fakeEventRepo{},
+				notifier:      &fakeNotifier{},
+			}
+
+			err := m.OnDeletingSource(ctx, &domain.Source{
+				ID:              "src",
+				DeletionPolicy:  tc.policy,
+				CreateNamespace: tc.createNs,
+			})
+			if err != nil {
+				t.Fatalf("Could not OnDeletingSource:%v", err)
+			}
+			if len(cluster.deleted) != tc.wantDelete {
+				t.Fatalf("Expected %d deleted jobs, got %v", tc.wantDelete, cluster.deleted)
+			}
+			if tc.wantDelete > 0 && len(watcher.stopped) != 1 {
+				t.Fatalf("Expected the watch to be stopped before deleting jobs")
+			}
+			if cluster.purged != tc.wantPurge {
+				t.Fatalf("Expected purge %v, got %v", tc.wantPurge, cluster.purged)
+			}
+			if len(cluster.deletedNamespaces) != tc.wantNs {
+				t.Errorf("Expected %d namespaces to be checked, got %v", tc.wantNs, cluster.deletedNamespaces)
+			}
+		})
+	}
+}
+
+type fakeSourceRepo struct {
+	srcs []*domain.Source
+}
+
+func (r *fakeSourceRepo) ListSources(ctx context.Context, opts ListSourcesOptions) ([]*domain.Source, error) {
+	return r.srcs, nil
+}
+
+func TestGetJobReport(t *testing.T) {
+	ctx := context.Background()
+	m := &ReconciliationManager{
+		logger: log.NewSimpleLogger(false, "Test"),
+		repo: &fakeSourceRepo{
+			srcs: []*domain.Source{
+				{
+					ID: "live",
+					Status: &domain.SourceStatus{
+						Jobs: map[string]domain.JobStatus{
+							"web":    {Unmanaged: true},
+							"worker": {},
+						},
+					},
+				},
+			},
+		},
+		clusterAccess: &fakeCluster{
+			managed: []*ManagedJob{
+				{Name: "api", SourceID: "live"},
+				{Name: "old", SourceID: "deleted"},
+			},
+		},
+	}
+
+	report, err := m.GetJobReport(ctx)
+	if err != nil {
+		t.Fatalf("Could not GetJobReport:%v", err)
+	}
+	if len(report.Orphaned) != 1 || report.Orphaned[0].Name != "old" {
+		t.Errorf("Expected job 'old' to be orphaned, got %+v", report.Orphaned)
+	}
+	if len(report.Unmanaged) != 1 || report.Unmanaged[0].Name != "web" || report.Unmanaged[0].SourceID != "live" {
+		t.Errorf("Expected job 'web' to be unmanaged, got %+v", report.Unmanaged)
+	}
+}
@@ -2,9 +2,14 @@ package application
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -28,15 +33,33 @@ type DesiredState struct {
 
 type GitInfo struct {
 	GitCommit string
+	// resolved ref, e.g. refs/heads/main or refs/tags/v1.4.2
+	GitRef string
 }
 
 type JobInfo struct {
 	GitInfo GitInfo
+	// hash of the job as rendered from the source, recorded on registration
+	SpecHash string
 	*api.Job
 }
 
+type JobFormat string
+
+const (
+	JobFormatHCL  JobFormat = "hcl"
+	JobFormatJSON JobFormat = "json"
+)
+
+type ParseJobOptions struct {
+	// defaults to JobFormatHCL
+	Format JobFormat
+	// content of a HCL2 variables file, only used for HCL jobs
+	Variables string
+}
+
 type JobParser interface {
-	ParseJob(ctx context.Context, j string) (*JobInfo, error)
+	ParseJob(ctx context.Context, j string, opts ParseJobOptions) (*JobInfo, error)
 }
 
 type GetCurrentClusterStateOptions struct {
@@ -44,20 +67,55 @@ type GetCurrentClusterStateOptions struct {
 }
 
 type UpdateJobInfo struct {
-	Updated          bool
-	Created          bool
-	Diff             json.RawMessage
-	DeploymentStatus DeploymentStatus
+	Updated bool
+	Created bool
+	Diff    json.RawMessage
+	// task groups nomad could not place, by group name
+	PlacementFailures json.RawMessage
+	DeploymentStatus  DeploymentStatus
+	// evaluation created by registering the job
+	EvalID string
 }
 
 type DeploymentStatus struct {
-	Status string
+	Status      string
+	Description string
+}
+
+type UpdateJobOptions struct {
+	// forces a restart of all allocations
+	Restart bool
+	// only plan the job
+	DryRun bool
+}
+
+type DeleteJobOptions struct {
+	// removes the job from nomad instead of only stopping it
+	Purge bool
+}
+
+// ManagedJob is a job in nomad that carries the meta of nomad-ops
+type ManagedJob struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	SourceID  string `json:"sourceId"`
+	SourceURL string `json:"sourceUrl"`
 }
 
 type ClusterAPI interface {
 	GetCurrentClusterState(ctx context.Context, opts GetCurrentClusterStateOptions) (*ClusterState, error)
-	UpdateJob(ctx context.Context, src *domain.Source, job *JobInfo, restart bool) (*UpdateJobInfo, error)
-	DeleteJob(ctx context.Context, src *domain.Source, job *JobInfo) error
+	// GetJob returns the job with the id of the given job no matter who manages it, or ErrNotFound
+	GetJob(ctx context.Context, src *domain.Source, job *JobInfo) (*JobInfo, error)
+	ListManagedJobs(ctx context.Context) ([]*ManagedJob, error)
+	UpdateJob(ctx context.Context, src *domain.Source, job *JobInfo, opts UpdateJobOptions) (*UpdateJobInfo, error)
+	DeleteJob(ctx context.Context, src *domain.Source, job *JobInfo, opts DeleteJobOptions) error
+	// WaitForDeployment follows the evaluation to its deployment until the deployment finished.
+	// Returns an empty status if the evaluation did not create a deployment
+	WaitForDeployment(ctx context.Context, src *domain.Source, job *JobInfo, evalID string) (*DeploymentStatus, error)
+	// RevertJob reverts the job to its last stable version and returns that version
+	RevertJob(ctx context.Context, src *domain.Source, job *JobInfo) (uint64, error)
+	// DeleteNamespaceIfEmpty deletes a namespace created by nomad-ops if it has no running jobs left
+	DeleteNamespaceIfEmpty(ctx context.Context, src *domain.Source, namespace string) (bool, error)
 }
 
 type ChangeInfo struct {
@@ -70,12 +128,22 @@ type ChangeInfo struct {
 type ReconcilerFunc func(ctx context.Context,
 	src *domain.Source,
 	desiredState *DesiredState,
-	restart bool) (*ChangeInfo, error)
+	opts SyncSourceOptions) (*ChangeInfo, error)
+
+const (
+	// MetaKeyPrune protects a job from being pruned if set to "false" in its meta
+	MetaKeyPrune = "nomadops-prune"
+	// MetaKeySyncWave orders the jobs of a source, lower waves are synced first and pruned last
+	MetaKeySyncWave = "nomadops-sync-wave"
+)
 
 func (r *ReconciliationManager) OnReconcile(ctx context.Context,
 	src *domain.Source,
 	desiredState *DesiredState,
-	restart bool) (*ChangeInfo, error) {
+	opts SyncSourceOptions) (*ChangeInfo, error) {
+
+	restart := opts.ForceRestart
+	dryRun := src.Paused || opts.DryRun || opts.BlockedByWindow
 
 	currentState, err := r.clusterAccess.GetCurrentClusterState(ctx, GetCurrentClusterStateOptions{
 		Source: src,
@@ -85,8 +153,13 @@ func (r *ReconciliationManager) OnReconcile(ctx context.Context,
 		return nil, err
 	}
 
+	// invalid waves fail the sync before anything is changed
+	waves, err := syncWaves(desiredState.Jobs)
+	if err != nil {
+		return nil, err
+	}
+
 	changed := &ChangeInfo{
-		DryRun: src.Paused,
 		Create: map[string]*JobInfo{},
 		Delete: map[string]*JobInfo{},
 		Update: map[string]*JobInfo{},
@@ -96,11 +169,74 @@ func (r *ReconciliationManager) OnReconcile(ctx context.Context,
 		src.Status = &domain.SourceStatus{}
 	}
 
+	if opts.OverrideSyncWindowBy != "" {
+		r.saveEvent(ctx, src, domain.EventTypeWindowOverridden,
+			fmt.Sprintf("Sync window overridden by %s", opts.OverrideSyncWindowBy))
+	}
+
+	if opts.PinCommit != "" {
+		r.saveEvent(ctx, src, domain.EventTypePinned, fmt.Sprintf("Pinned to commit %s by %s", opts.PinCommit, opts.PinnedBy))
+	}
+	if opts.Unpin {
+		r.saveEvent(ctx, src, domain.EventTypeUnpinned, fmt.Sprintf("Unpinned by %s", opts.PinnedBy))
+	}
+
+	commit := desiredState.GitInfo.GitCommit
+	previousPendingApproval := src.Status.PendingApprovalCommit
+	src.Status.PendingApprovalCommit = ""
+	src.Status.PendingChanges = nil
+
+	if src.Status.RolledBackCommit != "" {
+		if src.Status.RolledBackCommit == desiredState.GitInfo.GitCommit {
+			r.logger.LogInfo(ctx, "Not syncing source %s, commit %s was rolled back", src.ID, desiredState.GitInfo.GitCommit)
+			src.Status.Status = domain.SourceStatusStatusRolledBack
+			src.Status.Message = fmt.Sprintf("Commit %s was rolled back after a failed deployment, waiting for a new commit",
+				desiredState.GitInfo.GitCommit)
+			src.Status.LastCheckTime = toTimePtr(time.Now())
+			return changed, nil
+		}
+		// a new commit arrived
+		src.Status.RolledBackCommit = ""
+	}
+
+	if opts.RejectCommit != "" && opts.RejectCommit == commit && src.Status.RejectedCommit != commit {
+		src.Status.RejectedCommit = commit
+		r.saveEvent(ctx, src, domain.EventTypeRejected, fmt.Sprintf("Commit %s rejected by %s", commit, opts.ReviewedBy))
+	}
+	if src.Status.RejectedCommit != "" {
+		if src.Status.RejectedCommit == commit {
+			r.logger.LogInfo(ctx, "Not syncing source %s, commit %s was rejected", src.ID, commit)
+			src.Status.Status = domain.SourceStatusStatusOutOfSync
+			src.Status.Message = fmt.Sprintf("Commit %s was rejected, waiting for a new commit", commit)
+			src.Status.LastCheckTime = toTimePtr(time.Now())
+			return changed, nil
+		}
+		// a new commit arrived
+		src.Status.RejectedCommit = ""
+	}
+
+	// new commits of sources requiring approval are only planned until they are approved,
+	// commits can be approved while the sync windows block the source
+	awaitApproval := false
+	if src.RequireApproval && commit != src.Status.ApprovedCommit {
+		if opts.ApproveCommit == commit {
+			src.Status.ApprovedCommit = commit
+			r.saveEvent(ctx, src, domain.EventTypeApproved, fmt.Sprintf("Commit %s approved by %s", commit, opts.ReviewedBy))
+		} else if !src.Paused && !opts.DryRun {
+			awaitApproval = true
+			dryRun = true
+		}
+	}
+	changed.DryRun = dryRun
+
+	previousJobs := src.Status.Jobs
 	src.Status.Jobs = map[string]domain.JobStatus{}
 	src.Status.Status = domain.SourceStatusStatusSynced
 	src.Status.LastCheckTime = toTimePtr(time.Now())
 	src.Status.Message = ""
+	src.Status.PendingPrune = nil
 
+	toPrune := map[string]*JobInfo{}
 	for k, job := range currentState.CurrentJobs {
 		if _, ok := desiredState.Jobs[k]; !ok {
 			r.logger.LogTrace(ctx, "Checking if job is still required: %v...%+v", strPtrToStr(job.Name), log.ToJSONString(job))
@@ -117,177 +253,598 @@ func (r *ReconciliationManager) OnReconcile(ctx context.Context,
 				// has a parent job, periodic probably
 				continue
 			}
-
-			changed.Delete[k] = cpy
-
-			if src.Paused {
-				r.logger.LogInfo(ctx, "Found job %s that is no longer desired. Would be deleted...", k)
+			if src.Prune == domain.PruneNever {
+				r.logger.LogTrace(ctx, "Not pruning job %s, prune is disabled for the source", k)
 				continue
 			}
-
-			r.logger.LogInfo(ctx, "Found job %s that is no longer desired. Deleting...", k)
-			err := r.clusterAccess.DeleteJob(ctx, src, job)
-			if err != nil {
-				r.logger.LogError(ctx, "Failed to DeleteJob: %v - %v - %v - %v", err, src.URL, src.Path, *job.Name)
-				return nil, err
+			if cpy.Meta[MetaKeyPrune] == "false" {
+				r.logger.LogInfo(ctx, "Not pruning job %s, it is protected by %s", k, MetaKeyPrune)
+				continue
 			}
+			toPrune[k] = cpy
+		}
+	}
 
-			// we have a change
-			src.Status.LastUpdateTime = toTimePtr(time.Now())
-
-			ev := &domain.Event{
-				ID:        uuid.New().String(),
-				Timestamp: time.Now(),
-				Message:   fmt.Sprintf("Deleted Job:%v", strPtrToStr(job.Job.Name)),
-				Type:      domain.EventTypeDeleted,
-				Source:    src,
-			}
-			err = r.evRepo.SaveEvent(ctx, ev)
-			if err != nil {
-				r.logger.LogError(ctx, "Could not store event:%v", log.ToJSONString(ev))
+	if len(toPrune) > 0 && !dryRun {
+		reason := ""
+		if src.Prune == domain.PruneConfirm {
+			reason = "prune requires confirmation"
+		} else if r.cfg.MaxPrunePercent > 0 &&
+			len(toPrune)*100 > r.cfg.MaxPrunePercent*len(currentState.CurrentJobs) {
+			reason = fmt.Sprintf("pruning %d of %d jobs exceeds the limit of %d%%",
+				len(toPrune), len(currentState.CurrentJobs), r.cfg.MaxPrunePercent)
+		}
+		if reason != "" {
+			// only the jobs that were reviewed and confirmed are pruned
+			for k := range toPrune {
+				if containsString(opts.ConfirmPrune, k) {
+					continue
+				}
+				src.Status.PendingPrune = append(src.Status.PendingPrune, k)
+				delete(toPrune, k)
 			}
-			r.logger.LogInfo(ctx, "Found job %s that is no longer desired. Deleting...Done", k)
+		}
+		if len(src.Status.PendingPrune) > 0 {
+			sort.Strings(src.Status.PendingPrune)
+			r.logger.LogInfo(ctx, "Not pruning jobs %v of %s: %s", src.Status.PendingPrune, src.ID, reason)
+			src.Status.Status = domain.SourceStatusStatusOutOfSync
+			src.Status.Message = fmt.Sprintf("%d jobs pending prune, %s", len(src.Status.PendingPrune), reason)
 		}
 	}
 
-	for k, job := range desiredState.Jobs {
-		r.logger.LogTrace(ctx, "Updating job %v...%+v", strPtrToStr(job.Name), log.ToJSONString(job))
-		info, err := r.clusterAccess.UpdateJob(ctx, src, job, restart)
+	for _, k := range pruneOrder(toPrune) {
+		job := toPrune[k]
+		changed.Delete[k] = job
+
+		if dryRun {
+			r.logger.LogInfo(ctx, "Found job %s that is no longer desired. Would be deleted...", k)
+			continue
+		}
+
+		r.logger.LogInfo(ctx, "Found job %s that is no longer desired. Deleting...", k)
+		err := r.clusterAccess.DeleteJob(ctx, src, job, DeleteJobOptions{})
 		if err != nil {
-			r.logger.LogError(ctx, "Could not UpdateJob %v", log.ToJSONString(job))
+			r.logger.LogError(ctx, "Failed to DeleteJob: %v - %v - %v - %v", err, src.URL, src.Path, *job.Name)
 			return nil, err
 		}
 
-		jobStatus := domain.JobStatus{
-			Type:             strPtrToStr(job.Type),
-			Status:           "unknown",
-			DeploymentStatus: info.DeploymentStatus.Status,
-			Groups:           map[string]domain.GroupStatus{},
-			Namespace:        *job.Namespace,
-			Diff:             info.Diff,
+		// we have a change
+		src.Status.LastUpdateTime = toTimePtr(time.Now())
+
+		ev := &domain.Event{
+			ID:        uuid.New().String(),
+			Timestamp: time.Now(),
+			Message:   fmt.Sprintf("Deleted Job:%v", strPtrToStr(job.Job.Name)),
+			Type:      domain.EventTypeDeleted,
+			Source:    src,
 		}
-		if j, ok := currentState.CurrentJobs[k]; ok {
-			jobStatus.Status = strPtrToStr(j.Status)
-			jobStatus.StatusDescription = strPtrToStr(j.StatusDescription)
+		err = r.evRepo.SaveEvent(ctx, ev)
+		if err != nil {
+			r.logger.LogError(ctx, "Could not store event:%v", log.ToJSONString(ev))
 		}
-		for _, tg := range job.TaskGroups {
-			groupStatus := domain.GroupStatus{
-				Count:    intPtrToInt(tg.Count),
-				Services: map[string]domain.ServiceStatus{},
-				Tasks:    map[string]domain.TaskStatus{},
-			}
-			for _, t := range tg.Tasks {
-				taskStatus := domain.TaskStatus{
-					Driver: t.Driver,
+		r.logger.LogInfo(ctx, "Found job %s that is no longer desired. Deleting...Done", k)
+	}
+
+	unmanagedJobs := 0
+	var pending []pendingDeployment
+	// jobs whose deployment of this sync failed
+	var failed []string
+	for i, wave := range waves {
+		// later waves start once the deployments of this wave are healthy,
+		// rollbacks require the outcome of the deployments of this sync
+		wait := src.WaitForDeployment || src.AutoRollback || (i < len(waves)-1 && !dryRun)
+
+		for _, k := range wave.jobs {
+			job := desiredState.Jobs[k]
+			r.logger.LogTrace(ctx, "Updating job %v...%+v", strPtrToStr(job.Name), log.ToJSONString(job))
+
+			// jobs that exist without being managed by this source are only claimed when adopted explicitly
+			unmanaged := false
+			if _, ok := currentState.CurrentJobs[k]; !ok {
+				live, err := r.clusterAccess.GetJob(ctx, src, job)
+				if err != nil && err != ErrNotFound {
+					r.logger.LogError(ctx, "Could not GetJob %v:%v", strPtrToStr(job.Name), err)
+					return nil, err
 				}
-				groupStatus.Tasks[t.Name] = taskStatus
-			}
-			for _, svc := range tg.Services {
-				svcStatus := domain.ServiceStatus{
-					Port: svc.PortLabel,
+				if err == nil && (live.Stop == nil || !*live.Stop) {
+					unmanaged = !containsString(opts.Adopt, k)
+					if !unmanaged {
+						r.logger.LogInfo(ctx, "Adopting job %v for source %s", strPtrToStr(job.Name), src.ID)
+					}
 				}
-				groupStatus.Services[svc.Name] = svcStatus
 			}
-			jobStatus.Groups[strPtrToStr(tg.Name)] = groupStatus
-		}
 
-		src.Status.Jobs[strPtrToStr(job.Name)] = jobStatus
+			// without self heal, changes to a job whose commit and spec are still the desired ones were made in nomad.
+			// jobs registered without a spec hash only compare the commit
+			job.SpecHash = jobSpecHash(job)
+			checkDrift := false
+			if live, ok := currentState.CurrentJobs[k]; ok && !src.SelfHealEnabled() && !dryRun && !restart {
+				checkDrift = live.GitInfo.GitCommit == desiredState.GitInfo.GitCommit &&
+					(live.SpecHash == "" || live.SpecHash == job.SpecHash)
+			}
 
-		r.logger.LogTrace(ctx, "Updating job %v...Done", strPtrToStr(job.Name))
+			info, err := r.clusterAccess.UpdateJob(ctx, src, job, UpdateJobOptions{
+				Restart: restart,
+				DryRun:  dryRun || checkDrift || unmanaged,
+			})
+			if err != nil {
+				r.logger.LogError(ctx, "Could not UpdateJob %v", log.ToJSONString(job))
+				return nil, err
+			}
 
-		if !info.Created && !info.Updated {
-			r.logger.LogTrace(ctx, "Nothing to do for job %v", strPtrToStr(job.Name))
-			continue
-		}
+			jobStatus := domain.JobStatus{
+				Type:             strPtrToStr(job.Type),
+				Status:           "unknown",
+				DeploymentStatus: info.DeploymentStatus.Status,
+				Groups:           map[string]domain.GroupStatus{},
+				Namespace:        *job.Namespace,
+				Diff:             info.Diff,
+			}
+			jobStatus.PlacementFailures = info.PlacementFailures
+			if j, ok := currentState.CurrentJobs[k]; ok {
+				jobStatus.Status = strPtrToStr(j.Status)
+				jobStatus.StatusDescription = strPtrToStr(j.StatusDescription)
+			}
+			for _, tg := range job.TaskGroups {
+				groupStatus := domain.GroupStatus{
+					Count:    intPtrToInt(tg.Count),
+					Services: map[string]domain.ServiceStatus{},
+					Tasks:    map[string]domain.TaskStatus{},
+				}
+				for _, t := range tg.Tasks {
+					taskStatus := domain.TaskStatus{
+						Driver: t.Driver,
+					}
+					groupStatus.Tasks[t.Name] = taskStatus
+				}
+				for _, svc := range tg.Services {
+					svcStatus := domain.ServiceStatus{
+						Port: svc.PortLabel,
+					}
+					groupStatus.Services[svc.Name] = svcStatus
+				}
+				jobStatus.Groups[strPtrToStr(tg.Name)] = groupStatus
+			}
 
-		// we have a change
-		src.Status.LastUpdateTime = toTimePtr(time.Now())
+			if unmanaged {
+				r.logger.LogInfo(ctx, "Job %v exists in nomad, but is not managed by source %s", strPtrToStr(job.Name), src.ID)
+				jobStatus.Unmanaged = true
+				src.Status.Jobs[strPtrToStr(job.Name)] = jobStatus
+				unmanagedJobs++
+				continue
+			}
 
-		if info.Created {
-			cpy := job
-			changed.Create[k] = cpy
+			if checkDrift && (info.Created || info.Updated) {
+				jobStatus.Drifted = true
+				src.Status.Jobs[strPtrToStr(job.Name)] = jobStatus
+				r.onDrift(ctx, src, job, previousJobs)
+				continue
+			}
+
+			src.Status.Jobs[strPtrToStr(job.Name)] = jobStatus
+
+			r.logger.LogTrace(ctx, "Updating job %v...Done", strPtrToStr(job.Name))
 
-			if src.Paused {
-				r.logger.LogInfo(ctx, "Would create job %v", strPtrToStr(job.Name))
+			if !info.Created && !info.Updated {
+				r.logger.LogTrace(ctx, "Nothing to do for job %v", strPtrToStr(job.Name))
 				continue
 			}
-			ev := &domain.Event{
-				ID:        uuid.New().String(),
-				Timestamp: time.Now(),
-				Message:   fmt.Sprintf("Created Job:%v", strPtrToStr(job.Job.Name)),
-				Type:      domain.EventTypeCreated,
-				Source:    src,
+
+			// we have a change
+			src.Status.LastUpdateTime = toTimePtr(time.Now())
+
+			if info.Created {
+				cpy := job
+				changed.Create[k] = cpy
+
+				if dryRun {
+					r.logger.LogInfo(ctx, "Would create job %v", strPtrToStr(job.Name))
+					continue
+				}
+				msg := fmt.Sprintf("Created Job:%v", strPtrToStr(job.Job.Name))
+				r.saveEvent(ctx, src, domain.EventTypeCreated, msg)
+				if wait && info.EvalID != "" {
+					pending = append(pending, pendingDeployment{job: job, evalID: info.EvalID, msg: msg})
+				} else {
+					r.notifyJobChange(ctx, src, desiredState, restart, NotificationSuccess, msg)
+				}
+				r.logger.LogInfo(ctx, "Created job %v", strPtrToStr(job.Name))
 			}
-			err = r.evRepo.SaveEvent(ctx, ev)
-			if err != nil {
-				r.logger.LogError(ctx, "Could not store event:%v", log.ToJSONString(ev))
+			if info.Updated {
+				cpy := job
+				changed.Update[k] = cpy
+
+				if dryRun {
+					r.logger.LogInfo(ctx, "Would update job %v", strPtrToStr(job.Name))
+					continue
+				}
+				msg := fmt.Sprintf("Updated Job:%v", strPtrToStr(job.Job.Name))
+				r.saveEvent(ctx, src, domain.EventTypeUpdated, msg)
+				if wait && info.EvalID != "" {
+					pending = append(pending, pendingDeployment{job: job, evalID: info.EvalID, msg: msg})
+				} else {
+					r.notifyJobChange(ctx, src, desiredState, restart, NotificationSuccess, msg)
+				}
+				r.logger.LogInfo(ctx, "Updated job %v", strPtrToStr(job.Name))
 			}
-			r.logger.LogInfo(ctx, "Created job %v", strPtrToStr(job.Name))
 		}
-		if info.Updated {
-			cpy := job
-			changed.Update[k] = cpy
 
-			if src.Paused {
-				r.logger.LogInfo(ctx, "Would update job %v", strPtrToStr(job.Name))
-				continue
+		if i == len(waves)-1 || dryRun {
+			continue
+		}
+		if len(pending) > 0 {
+			ok, waveFailed := r.waitForDeployments(ctx, src, desiredState, restart, pending)
+			pending = nil
+			failed = append(failed, waveFailed...)
+			if !ok {
+				r.logger.LogError(ctx, "Deployment of sync wave %d of source %s failed, not syncing later waves", wave.wave, src.ID)
+				src.Status.Status = domain.SourceStatusStatusSyncedWithError
+				src.Status.Message = fmt.Sprintf("Deployment of sync wave %d failed, later waves were not synced", wave.wave)
+				break
 			}
-
-			ev := &domain.Event{
-				ID:        uuid.New().String(),
-				Timestamp: time.Now(),
-				Message:   fmt.Sprintf("Updated Job:%v", strPtrToStr(job.Job.Name)),
-				Type:      domain.EventTypeUpdated,
-				Source:    src,
+		}
+		// jobs this sync did not change may still be deploying or have failed in an earlier sync
+		if name, status := unhealthyDeployment(src.Status, wave.jobs); name != "" {
+			r.logger.LogInfo(ctx, "Deployment of job %s in sync wave %d of source %s is %s, not syncing later waves", name, wave.wave, src.ID, status)
+			src.Status.Status = domain.SourceStatusStatusOutOfSync
+			if status == api.DeploymentStatusFailed {
+				src.Status.Status = domain.SourceStatusStatusSyncedWithError
 			}
-			err = r.evRepo.SaveEvent(ctx, ev)
-			if err != nil {
-				r.logger.LogError(ctx, "Could not store event:%v - %v", err, log.ToJSONString(ev))
+			src.Status.Message = fmt.Sprintf("Deployment of job %s in sync wave %d is %s, later waves were not synced", name, wave.wave, status)
+			break
+		}
+	}
+
+	if unmanagedJobs > 0 && src.Status.Status == domain.SourceStatusStatusSynced {
+		src.Status.Status = domain.SourceStatusStatusOutOfSync
+		src.Status.Message = fmt.Sprintf("%d jobs exist in nomad, but are not managed by this source. Adopt them to sync", unmanagedJobs)
+	}
+
+	if len(pending) > 0 {
+		_, lastFailed := r.waitForDeployments(ctx, src, desiredState, restart, pending)
+		failed = append(failed, lastFailed...)
+	}
+
+	if src.AutoRollback && !dryRun {
+		r.rollbackFailedJobs(ctx, src, desiredState, restart, failed)
+	}
+
+	if awaitApproval {
+		r.requestApproval(ctx, src, desiredState, changed, previousPendingApproval, restart)
+	}
+
+	return changed, nil
+}
+
+// requestApproval holds back the planned changes of a commit until it is approved
+func (r *ReconciliationManager) requestApproval(ctx context.Context,
+	src *domain.Source,
+	desiredState *DesiredState,
+	changed *ChangeInfo,
+	previousPendingApproval string,
+	restart bool) {
+
+	commit := desiredState.GitInfo.GitCommit
+	if len(changed.Create) == 0 && len(changed.Update) == 0 && len(changed.Delete) == 0 {
+		// nothing to approve
+		src.Status.ApprovedCommit = commit
+		return
+	}
+
+	src.Status.PendingApprovalCommit = commit
+	src.Status.PendingChanges = pendingChanges(changed)
+	src.Status.Status = domain.SourceStatusStatusPendingApproval
+	src.Status.Message = fmt.Sprintf("Commit %s is waiting for approval: %d to create, %d to update, %d to delete",
+		commit, len(changed.Create), len(changed.Update), len(changed.Delete))
+
+	if previousPendingApproval != commit {
+		r.logger.LogInfo(ctx, "Commit %s of source %s is waiting for approval", commit, src.ID)
+		r.notifyJobChange(ctx, src, desiredState, restart, NotificationSuccess, src.Status.Message)
+	}
+}
+
+// rollbackFailedJobs reverts the jobs whose deployment of this sync failed and holds back the commit until a new one arrives.
+// Deployments of earlier syncs are not considered, they may belong to a version that is already replaced
+func (r *ReconciliationManager) rollbackFailedJobs(ctx context.Context,
+	src *domain.Source,
+	desiredState *DesiredState,
+	restart bool,
+	failed []string) {
+
+	rolledBack := false
+	sort.Strings(failed)
+	for _, name := range failed {
+		job, ok := desiredState.Jobs[name]
+		if !ok {
+			continue
+		}
+
+		r.logger.LogInfo(ctx, "Deployment of job %s failed, rolling back...", name)
+		version, err := r.clusterAccess.RevertJob(ctx, src, job)
+		if err != nil {
+			r.logger.LogError(ctx, "Could not RevertJob %s:%v", name, err)
+			r.notifyJobChange(ctx, src, desiredState, restart, NotificationError,
+				fmt.Sprintf("Could not roll back Job:%v", name),
+				NotifyAdditionalInfos{
+					Header: "Error",
+					Text:   err.Error(),
+					Large:  true,
+				})
+			continue
+		}
+		rolledBack = true
+
+		msg := fmt.Sprintf("Rolled back Job:%v to version %d", name, version)
+		r.saveEvent(ctx, src, domain.EventTypeRolledBack, msg)
+		r.notifyJobChange(ctx, src, desiredState, restart, NotificationError, msg+" after a failed deployment")
+	}
+
+	if rolledBack {
+		src.Status.RolledBackCommit = desiredState.GitInfo.GitCommit
+		src.Status.Status = domain.SourceStatusStatusRolledBack
+		src.Status.Message = fmt.Sprintf("Commit %s was rolled back after a failed deployment, waiting for a new commit",
+			desiredState.GitInfo.GitCommit)
+	}
+}
+
+type syncWave struct {
+	wave int
+	jobs []string
+}
+
+// jobWave returns the sync wave of a job, 0 if it has none
+func jobWave(job *JobInfo) (int, error) {
+	v, ok := job.Meta[MetaKeySyncWave]
+	if !ok {
+		return 0, nil
+	}
+	wave, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s' of job %s", MetaKeySyncWave, v, strPtrToStr(job.Name))
+	}
+	return wave, nil
+}
+
+// syncWaves groups the jobs by their sync wave, in ascending order
+func syncWaves(jobs map[string]*JobInfo) ([]*syncWave, error) {
+	byWave := map[int]*syncWave{}
+	for k, job := range jobs {
+		wave, err := jobWave(job)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := byWave[wave]; !ok {
+			byWave[wave] = &syncWave{wave: wave}
+		}
+		byWave[wave].jobs = append(byWave[wave].jobs, k)
+	}
+
+	waves := make([]*syncWave, 0, len(byWave))
+	for _, w := range byWave {
+		sort.Strings(w.jobs)
+		waves = append(waves, w)
+	}
+	sort.Slice(waves, func(i, j int) bool {
+		return waves[i].wave < waves[j].wave
+	})
+	return waves, nil
+}
+
+// unhealthyDeployment returns the first of the managed jobs whose deployment failed or is still running
+func unhealthyDeployment(status *domain.SourceStatus, jobs []string) (string, string) {
+	for _, k := range jobs {
+		jobStatus, ok := status.Jobs[k]
+		if !ok || jobStatus.Unmanaged {
+			continue
+		}
+		switch jobStatus.DeploymentStatus {
+		case api.DeploymentStatusFailed, api.DeploymentStatusRunning:
+			return k, jobStatus.DeploymentStatus
+		}
+	}
+	return "", ""
+}
+
+// pruneOrder returns the jobs in descending sync wave order
+func pruneOrder(jobs map[string]*JobInfo) []string {
+	waves := map[string]int{}
+	keys := make([]string, 0, len(jobs))
+	for k, job := range jobs {
+		wave, err := jobWave(job)
+		if err != nil {
+			// the job is about to be deleted anyway
+			wave = 0
+		}
+		waves[k] = wave
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if waves[keys[i]] != waves[keys[j]] {
+			return waves[keys[i]] > waves[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// pendingDeployment is a registered job whose notification waits for its deployment
+type pendingDeployment struct {
+	job    *JobInfo
+	evalID string
+	msg    string
+}
+
+// waitForDeployments marks the source as progressing until the deployments of all jobs finished,
+// failed or timed out and notifies about the outcome. Returns true if no deployment failed or timed out
+// and the jobs whose deployment failed
+func (r *ReconciliationManager) waitForDeployments(ctx context.Context,
+	src *domain.Source,
+	desiredState *DesiredState,
+	restart bool,
+	pending []pendingDeployment) (bool, []string) {
+
+	status, msg := src.Status.Status, src.Status.Message
+	src.Status.Status = domain.SourceStatusStatusProgressing
+	src.Status.Message = fmt.Sprintf("Waiting for the deployment of %d jobs", len(pending))
+	err := r.statusPatcher.SetSourceStatus(ctx, src, src.Status)
+	if err != nil {
+		r.logger.LogError(ctx, "Could not SetSourceStatus on %s:%v", src.ID, err)
+	}
+
+	waitCtx := ctx
+	if r.cfg.DeploymentTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, r.cfg.DeploymentTimeout)
+		defer cancel()
+	}
+
+	healthy := true
+	var failed []string
+	for _, p := range pending {
+		name := strPtrToStr(p.job.Name)
+		deployment, err := r.clusterAccess.WaitForDeployment(waitCtx, src, p.job, p.evalID)
+		if err != nil {
+			if waitCtx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("timed out after %v", r.cfg.DeploymentTimeout)
 			}
-			r.logger.LogInfo(ctx, "Updated job %v", strPtrToStr(job.Name))
-			err = r.notifier.Notify(ctx, NotifyOptions{
-				Source:  src,
-				GitInfo: desiredState.GitInfo,
-				Type:    NotificationSuccess,
-				Message: fmt.Sprintf("Updated Job:%v", strPtrToStr(job.Job.Name)),
-				Infos: []NotifyAdditionalInfos{
-					{
-						Header: "Git-Commit",
-						Text:   desiredState.GitInfo.GitCommit,
-					},
-					{
-						Header: "Git-Url",
-						Text:   src.URL,
-					},
-					{
-						Header: "Git-Ref",
-						Text:   src.Branch,
-					},
-					{
-						Header: "Git-Repo-Path",
-						Text:   src.Path,
-					},
-					{
-						Header: "Nomad-Namespace",
-						Text:   src.Namespace,
-					},
-					{
-						Header: "Nomad-Region",
-						Text:   src.Region,
-					},
-					{
-						Header: "Force Restart",
-						Text:   fmt.Sprintf("%v", restart),
-					},
-				},
-			})
-			if err != nil {
-				r.logger.LogError(ctx, "Could not notify:%v", err)
+			r.logger.LogError(ctx, "Could not WaitForDeployment of %s:%v", name, err)
+			healthy = false
+			r.notifyJobChange(ctx, src, desiredState, restart, NotificationError,
+				fmt.Sprintf("Deployment of Job:%v did not finish", name),
+				NotifyAdditionalInfos{
+					Header: "Error",
+					Text:   err.Error(),
+					Large:  true,
+				})
+			continue
+		}
+
+		if deployment.Status != "" {
+			jobStatus := src.Status.Jobs[name]
+			jobStatus.DeploymentStatus = deployment.Status
+			src.Status.Jobs[name] = jobStatus
+		}
+		if deployment.Status == api.DeploymentStatusFailed || deployment.Status == api.DeploymentStatusCancelled {
+			healthy = false
+			if deployment.Status == api.DeploymentStatusFailed {
+				failed = append(failed, name)
 			}
+			r.notifyJobChange(ctx, src, desiredState, restart, NotificationError,
+				fmt.Sprintf("Deployment failed for Job:%v", name),
+				NotifyAdditionalInfos{
+					Header: "Deployment",
+					Text:   deployment.Description,
+					Large:  true,
+				})
+			continue
 		}
+		r.notifyJobChange(ctx, src, desiredState, restart, NotificationSuccess, p.msg)
 	}
 
-	return changed, nil
+	src.Status.Status, src.Status.Message = status, msg
+	return healthy, failed
+}
+
+func (r *ReconciliationManager) saveEvent(ctx context.Context, src *domain.Source, evType domain.EventType, msg string) {
+	ev := &domain.Event{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now(),
+		Message:   msg,
+		Type:      evType,
+		Source:    src,
+	}
+	err := r.evRepo.SaveEvent(ctx, ev)
+	if err != nil {
+		r.logger.LogError(ctx, "Could not store event:%v - %v", err, log.ToJSONString(ev))
+	}
+}
+
+// notifyJobChange notifies about a job of the source, extra infos are appended to the git and nomad infos
+func (r *ReconciliationManager) notifyJobChange(ctx context.Context,
+	src *domain.Source,
+	desiredState *DesiredState,
+	restart bool,
+	notificationType NotificationType,
+	msg string,
+	extra ...NotifyAdditionalInfos) {
+
+	err := r.notifier.Notify(ctx, NotifyOptions{
+		Source:  src,
+		GitInfo: desiredState.GitInfo,
+		Type:    notificationType,
+		Message: msg,
+		Infos: append([]NotifyAdditionalInfos{
+			{
+				Header: "Git-Commit",
+				Text:   desiredState.GitInfo.GitCommit,
+			},
+			{
+				Header: "Git-Url",
+				Text:   src.URL,
+			},
+			{
+				Header: "Git-Ref",
+				Text:   desiredState.GitInfo.GitRef,
+			},
+			{
+				Header: "Git-Repo-Path",
+				Text:   src.Path,
+			},
+			{
+				Header: "Nomad-Namespace",
+				Text:   src.Namespace,
+			},
+			{
+				Header: "Nomad-Region",
+				Text:   src.Region,
+			},
+			{
+				Header: "Force Restart",
+				Text:   fmt.Sprintf("%v", restart),
+			},
+		}, extra...),
+	})
+	if err != nil {
+		r.logger.LogError(ctx, "Could not notify:%v", err)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// onDrift records a job that was changed in nomad and emits an event if the drift is new
+func (r *ReconciliationManager) onDrift(ctx context.Context,
+	src *domain.Source,
+	job *JobInfo,
+	previousJobs map[string]domain.JobStatus) {
+
+	name := strPtrToStr(job.Name)
+	r.logger.LogInfo(ctx, "Job %s drifted from git, not overwriting it because self heal is disabled", name)
+
+	src.Status.Status = domain.SourceStatusStatusDrifted
+	src.Status.Message = fmt.Sprintf("Drift detected for job: %s", name)
+
+	if previous, ok := previousJobs[name]; ok && previous.Drifted {
+		// already reported
+		return
+	}
+	r.saveEvent(ctx, src, domain.EventTypeDrifted, fmt.Sprintf("Drift detected for Job:%v", name))
+}
+
+// jobSpecHash hashes the job as rendered from the source, including overrides, variables and the vault token
+func jobSpecHash(job *JobInfo) string {
+	b, err := json.Marshal(job.Job)
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
 }
 
 func toTimePtr(t time.Time) *time.Time {
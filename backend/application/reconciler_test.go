@@ -0,0 +1,859 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+// fakeCluster reports every desired job as changed and records the calls
+type fakeCluster struct {
+	jobs map[string]*JobInfo
+	// jobs that exist in nomad, but are not managed by the source
+	unmanaged map[string]*JobInfo
+	managed   []*ManagedJob
+	// outcome of the deployment by job name
+	deployments map[string]*DeploymentStatus
+	// status of the latest deployment before the update by job name
+	latestDeployments map[string]string
+	// jobs that are reported as unchanged
+	upToDate map[string]bool
+	updates  map[string]UpdateJobOptions
+	// job names in the order of UpdateJob calls
+	order    []string
+	deleted  []string
+	reverted []string
+	purged   bool
+	// namespaces passed to DeleteNamespaceIfEmpty
+	deletedNamespaces []string
+}
+
+func (c *fakeCluster) GetCurrentClusterState(ctx context.Context, opts GetCurrentClusterStateOptions) (*ClusterState, error) {
+	return &ClusterState{CurrentJobs: c.jobs}, nil
+}
+
+func (c *fakeCluster) GetJob(ctx context.Context, src *domain.Source, job *JobInfo) (*JobInfo, error) {
+	if j, ok := c.unmanaged[*job.ID]; ok {
+		return j, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (c *fakeCluster) ListManagedJobs(ctx context.Context) ([]*ManagedJob, error) {
+	return c.managed, nil
+}
+
+func (c *fakeCluster) UpdateJob(ctx context.Context, src *domain.Source, job *JobInfo, opts UpdateJobOptions) (*UpdateJobInfo, error) {
+	c.updates[*job.Name] = opts
+	c.order = append(c.order, *job.Name)
+	_, exists := c.jobs[*job.Name]
+	if _, ok := c.unmanaged[*job.Name]; ok {
+		exists = true
+	}
+	if c.upToDate[*job.Name] {
+		return &UpdateJobInfo{DeploymentStatus: DeploymentStatus{Status: c.latestDeployments[*job.Name]}}, nil
+	}
+	return &UpdateJobInfo{
+		Created:          !exists,
+		Updated:          exists,
+		EvalID:           "eval-" + *job.Name,
+		DeploymentStatus: DeploymentStatus{Status: c.latestDeployments[*job.Name]},
+	}, nil
+}
+
+func (c *fakeCluster) WaitForDeployment(ctx context.Context, src *domain.Source, job *JobInfo, evalID string) (*DeploymentStatus, error) {
+	if d, ok := c.deployments[*job.Name]; ok {
+		return d, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c *fakeCluster) RevertJob(ctx context.Context, src *domain.Source, job *JobInfo) (uint64, error) {
+	c.reverted = append(c.reverted, *job.Name)
+	return 1, nil
+}
+
+func (c *fakeCluster) DeleteJob(ctx context.Context, src *domain.Source, job *JobInfo, opts DeleteJobOptions) error {
+	c.deleted = append(c.deleted, *job.Name)
+	c.purged = opts.Purge
+	return nil
+}
+
+func (c *fakeCluster) DeleteNamespaceIfEmpty(ctx context.Context, src *domain.Source, namespace string) (bool, error) {
+	c.deletedNamespaces = append(c.deletedNamespaces, namespace)
+	return true, nil
+}
+
+type fakeEventRepo struct {
+	events []*domain.Event
+}
+
+func (r *fakeEventRepo) SaveEvent(ctx context.Context, ev *domain.Event) error {
+	r.events = append(r.events, ev)
+	return nil
+}
+
+type fakeNotifier struct {
+	messages []string
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, opts NotifyOptions) error {
+	n.messages = append(n.messages, opts.Message)
+	return nil
+}
+
+func testJob(name, commit string) *JobInfo {
+	namespace := api.DefaultNamespace
+	return &JobInfo{
+		GitInfo: GitInfo{GitCommit: commit},
+		Job: &api.Job{
+			ID:        &name,
+			Name:      &name,
+			Namespace: &namespace,
+		},
+	}
+}
+
+func TestOnReconcileSelfHeal(t *testing.T) {
+	ctx := context.Background()
+	registeredSpec := jobSpecHash(testJob("web", "abc"))
+
+	for _, tc := range []struct {
+		name        string
+		selfHeal    domain.SelfHealMode
+		liveCommit  string
+		liveSpec    string
+		wantDryRun  bool
+		wantDrifted bool
+	}{
+		{name: "enabled", selfHeal: domain.SelfHealEnabled, liveCommit: "abc", wantDryRun: false},
+		{name: "disabled", selfHeal: domain.SelfHealDisabled, liveCommit: "abc", liveSpec: registeredSpec, wantDryRun: true, wantDrifted: true},
+		{name: "disabled without spec", selfHeal: domain.SelfHealDisabled, liveCommit: "abc", wantDryRun: true, wantDrifted: true},
+		{name: "new commit", selfHeal: domain.SelfHealDisabled, liveCommit: "old", liveSpec: registeredSpec, wantDryRun: false},
+		// e.g. a changed namespace override, vault token or variable
+		{name: "source changed", selfHeal: domain.SelfHealDisabled, liveCommit: "abc", liveSpec: "other", wantDryRun: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			live := testJob("web", tc.liveCommit)
+			live.SpecHash = tc.liveSpec
+			cluster := &fakeCluster{
+				jobs: map[string]*JobInfo{
+					"web": live,
+				},
+				updates: map[string]UpdateJobOptions{},
+			}
+			evRepo := &fakeEventRepo{}
+			r := &ReconciliationManager{
+				logger:        log.NewSimpleLogger(false, "Test"),
+				clusterAccess: cluster,
+				evRepo:        evRepo,
+				notifier:      &fakeNotifier{},
+			}
+			src := &domain.Source{ID: "src", SelfHeal: tc.selfHeal}
+			desired := &DesiredState{
+				GitInfo: GitInfo{GitCommit: "abc"},
+				Jobs: map[string]*JobInfo{
+					"web": testJob("web", "abc"),
+					"api": testJob("api", "abc"),
+				},
+			}
+
+			for i := 0; i < 2; i++ {
+				changed, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+				if err != nil {
+					t.Fatalf("Could not reconcile:%v", err)
+				}
+				if cluster.updates["web"].DryRun != tc.wantDryRun {
+					t.Fatalf("Expected dry run %v, got %v", tc.wantDryRun, cluster.updates["web"].DryRun)
+				}
+				if cluster.updates["api"].DryRun {
+					t.Fatalf("Expected missing jobs to be created")
+				}
+				if _, ok := changed.Create["api"]; !ok {
+					t.Fatalf("Expected api to be created")
+				}
+				if src.Status.Jobs["web"].Drifted != tc.wantDrifted {
+					t.Fatalf("Expected drifted %v, got %v", tc.wantDrifted, src.Status.Jobs["web"].Drifted)
+				}
+				if tc.wantDrifted && src.Status.Status != domain.SourceStatusStatusDrifted {
+					t.Fatalf("Expected status %s, got %s", domain.SourceStatusStatusDrifted, src.Status.Status)
+				}
+			}
+
+			drifted := 0
+			for _, ev := range evRepo.events {
+				if ev.Type == domain.EventTypeDrifted {
+					drifted++
+				}
+			}
+			if tc.wantDrifted && drifted != 1 {
+				t.Errorf("Expected a single drift event, got %d", drifted)
+			}
+			if !tc.wantDrifted && drifted != 0 {
+				t.Errorf("Expected no drift event, got %d", drifted)
+			}
+		})
+	}
+}
+
+func TestOnReconcilePrune(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name       string
+		prune      domain.PruneMode
+		maxPercent int
+		confirm    []string
+		want       []string
+		pending    int
+	}{
+		{name: "auto", prune: domain.PruneAuto, want: []string{"old", "other"}},
+		{name: "never", prune: domain.PruneNever},
+		{name: "confirm", prune: domain.PruneConfirm, pending: 2},
+		{name: "confirmed", prune: domain.PruneConfirm, confirm: []string{"old", "other"}, want: []string{"old", "other"}},
+		// jobs that were not reviewed stay pending
+		{name: "partially confirmed", prune: domain.PruneConfirm, confirm: []string{"old"}, want: []string{"old"}, pending: 1},
+		{name: "limit", prune: domain.PruneAuto, maxPercent: 50, pending: 2},
+		{name: "limit confirmed", prune: domain.PruneAuto, maxPercent: 50, confirm: []string{"old", "other"}, want: []string{"old", "other"}},
+		{name: "limit partially confirmed", prune: domain.PruneAuto, maxPercent: 50, confirm: []string{"other", "web"}, want: []string{"other"}, pending: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := &fakeCluster{
+				jobs: map[string]*JobInfo{
+					"web":   testJob("web", "abc"),
+					"old":   testJob("old", "abc"),
+					"other": testJob("other", "abc"),
+				},
+				updates: map[string]UpdateJobOptions{},
+			}
+			r := &ReconciliationManager{
+				logger:        log.NewSimpleLogger(false, "Test"),
+				cfg:           ReconciliationManagerConfig{MaxPrunePercent: tc.maxPercent},
+				clusterAccess: cluster,
+				evRepo:        &fakeEventRepo{},
+				notifier:      &fakeNotifier{},
+			}
+			src := &domain.Source{ID: "src", Prune: tc.prune}
+			desired := &DesiredState{
+				GitInfo: GitInfo{GitCommit: "abc"},
+				Jobs: map[string]*JobInfo{
+					"web": testJob("web", "abc"),
+				},
+			}
+
+			_, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{ConfirmPrune: tc.confirm})
+			if err != nil {
+				t.Fatalf("Could not reconcile:%v", err)
+			}
+			sort.Strings(cluster.deleted)
+			if strings.Join(cluster.deleted, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("Expected %v to be pruned, got %v", tc.want, cluster.deleted)
+			}
+			if len(src.Status.PendingPrune) != tc.pending {
+				t.Fatalf("Expected %d jobs pending prune, got %v", tc.pending, src.Status.PendingPrune)
+			}
+		})
+	}
+
+	// protected jobs are never pruned
+	cluster := &fakeCluster{
+		jobs: map[string]*JobInfo{
+			"old": testJob("old", "abc"),
+		},
+		updates: map[string]UpdateJobOptions{},
+	}
+	cluster.jobs["old"].Meta = map[string]string{MetaKeyPrune: "false"}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		clusterAccess: cluster,
+		evRepo:        &fakeEventRepo{},
+		notifier:      &fakeNotifier{},
+	}
+	src := &domain.Source{ID: "src", Prune: domain.PruneAuto}
+	_, err := r.OnReconcile(ctx, src, &DesiredState{Jobs: map[string]*JobInfo{}}, SyncSourceOptions{ConfirmPrune: []string{"old"}})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if len(cluster.deleted) != 0 {
+		t.Errorf("Expected protected job to be kept, got %v", cluster.deleted)
+	}
+}
+
+func TestOnReconcileAdopt(t *testing.T) {
+	ctx := context.Background()
+
+	cluster := &fakeCluster{
+		jobs: map[string]*JobInfo{},
+		unmanaged: map[string]*JobInfo{
+			"web": testJob("web", ""),
+		},
+		updates: map[string]UpdateJobOptions{},
+	}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		clusterAccess: cluster,
+		evRepo:        &fakeEventRepo{},
+		notifier:      &fakeNotifier{},
+	}
+	src := &domain.Source{ID: "src"}
+	desired := &DesiredState{
+		GitInfo: GitInfo{GitCommit: "abc"},
+		Jobs: map[string]*JobInfo{
+			"web": testJob("web", "abc"),
+		},
+	}
+
+	changed, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if !cluster.updates["web"].DryRun || len(changed.Update) != 0 {
+		t.Fatalf("Expected unmanaged job to be planned only")
+	}
+	if !src.Status.Jobs["web"].Unmanaged || src.Status.Status != domain.SourceStatusStatusOutOfSync {
+		t.Fatalf("Expected unmanaged job in status, got %+v", src.Status)
+	}
+
+	changed, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{Adopt: []string{"web"}})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if cluster.updates["web"].DryRun || len(changed.Update) != 1 {
+		t.Fatalf("Expected adopted job to be updated")
+	}
+	if src.Status.Jobs["web"].Unmanaged {
+		t.Errorf("Expected adopted job to be managed")
+	}
+}
+
+func TestOnReconcileCreatedAndUpdated(t *testing.T) {
+	ctx := context.Background()
+
+	cluster := &fakeCluster{
+		jobs: map[string]*JobInfo{
+			"web": testJob("web", "old"),
+		},
+		updates: map[string]UpdateJobOptions{},
+	}
+	evRepo := &fakeEventRepo{}
+	notifier := &fakeNotifier{}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		clusterAccess: cluster,
+		evRepo:        evRepo,
+		notifier:      notifier,
+	}
+	src := &domain.Source{ID: "src"}
+	desired := &DesiredState{
+		GitInfo: GitInfo{GitCommit: "abc"},
+		Jobs: map[string]*JobInfo{
+			"web": testJob("web", "abc"),
+			"api": testJob("api", "abc"),
+		},
+	}
+
+	changed, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if _, ok := changed.Create["api"]; !ok || len(changed.Create) != 1 {
+		t.Fatalf("Expected api to be created, got %v", changed.Create)
+	}
+	if _, ok := changed.Update["web"]; !ok || len(changed.Update) != 1 {
+		t.Fatalf("Expected web to be updated, got %v", changed.Update)
+	}
+
+	events := map[domain.EventType]string{}
+	for _, ev := range evRepo.events {
+		events[ev.Type] = ev.Message
+	}
+	if events[domain.EventTypeCreated] != "Created Job:api" || events[domain.EventTypeUpdated] != "Updated Job:web" {
+		t.Errorf("Unexpected events %v", events)
+	}
+	sort.Strings(notifier.messages)
+	if strings.Join(notifier.messages, ",") != "Created Job:api,Updated Job:web" {
+		t.Errorf("Unexpected notifications %v", notifier.messages)
+	}
+}
+
+type fakeStatusPatcher struct {
+	statuses []string
+}
+
+func (p *fakeStatusPatcher) SetSourceStatus(ctx context.Context, src *domain.Source, s *domain.SourceStatus) error {
+	p.statuses = append(p.statuses, s.Status)
+	return nil
+}
+
+func TestOnReconcileWaitForDeployment(t *testing.T) {
+	ctx := context.Background()
+
+	cluster := &fakeCluster{
+		jobs: map[string]*JobInfo{
+			"web": testJob("web", "old"),
+		},
+		updates: map[string]UpdateJobOptions{},
+		deployments: map[string]*DeploymentStatus{
+			"web": {Status: "failed", Description: "Failed due to unhealthy allocations"},
+			"api": {Status: "successful"},
+		},
+	}
+	notifier := &fakeNotifier{}
+	patcher := &fakeStatusPatcher{}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		cfg:           ReconciliationManagerConfig{DeploymentTimeout: 50 * time.Millisecond},
+		clusterAccess: cluster,
+		evRepo:        &fakeEventRepo{},
+		notifier:      notifier,
+		statusPatcher: patcher,
+	}
+	src := &domain.Source{ID: "src", WaitForDeployment: true}
+	desired := &DesiredState{
+		GitInfo: GitInfo{GitCommit: "abc"},
+		Jobs: map[string]*JobInfo{
+			"web":    testJob("web", "abc"),
+			"api":    testJob("api", "abc"),
+			"worker": testJob("worker", "abc"),
+		},
+	}
+
+	_, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if len(patcher.statuses) != 1 || patcher.statuses[0] != domain.SourceStatusStatusProgressing {
+		t.Fatalf("Expected progressing status while waiting, got %v", patcher.statuses)
+	}
+	if src.Status.Status != domain.SourceStatusStatusSynced {
+		t.Fatalf("Expected status to be restored, got %s", src.Status.Status)
+	}
+	if src.Status.Jobs["web"].DeploymentStatus != "failed" {
+		t.Fatalf("Expected failed deployment in status, got %+v", src.Status.Jobs["web"])
+	}
+	sort.Strings(notifier.messages)
+	want := "Created Job:api,Deployment failed for Job:web,Deployment of Job:worker did not finish"
+	if strings.Join(notifier.messages, ",") != want {
+		t.Errorf("Expected notifications %s, got %v", want, notifier.messages)
+	}
+}
+
+func TestOnReconcileAutoRollback(t *testing.T) {
+	ctx := context.Background()
+
+	cluster := &fakeCluster{
+		jobs:    map[string]*JobInfo{},
+		updates: map[string]UpdateJobOptions{},
+		deployments: map[string]*DeploymentStatus{
+			"web": {Status: "failed"},
+			"api": {Status: "successful"},
+		},
+	}
+	evRepo := &fakeEventRepo{}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		clusterAccess: cluster,
+		evRepo:        evRepo,
+		notifier:      &fakeNotifier{},
+		statusPatcher: &fakeStatusPatcher{},
+	}
+	src := &domain.Source{ID: "src", WaitForDeployment: true, AutoRollback: true}
+	desired := &DesiredState{
+		GitInfo: GitInfo{GitCommit: "abc"},
+		Jobs: map[string]*JobInfo{
+			"web": testJob("web", "abc"),
+			"api": testJob("api", "abc"),
+		},
+	}
+
+	_, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if strings.Join(cluster.reverted, ",") != "web" {
+		t.Fatalf("Expected web to be reverted, got %v", cluster.reverted)
+	}
+	if src.Status.Status != domain.SourceStatusStatusRolledBack || src.Status.RolledBackCommit != "abc" {
+		t.Fatalf("Expected rolled back status, got %+v", src.Status)
+	}
+	src.Status.DetermineSyncStatus()
+	if src.Status.Status != domain.SourceStatusStatusRolledBack {
+		t.Fatalf("Expected rolled back status to be kept, got %s", src.Status.Status)
+	}
+
+	// the rolled back commit is not synced again
+	cluster.updates = map[string]UpdateJobOptions{}
+	_, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if len(cluster.updates) != 0 || len(cluster.reverted) != 1 {
+		t.Fatalf("Expected no changes for a rolled back commit, got %v", cluster.updates)
+	}
+
+	// a new commit is synced
+	desired.GitInfo.GitCommit = "def"
+	_, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if len(cluster.updates) != 2 {
+		t.Errorf("Expected a new commit to be synced, got %v", cluster.updates)
+	}
+}
+
+func TestOnReconcileAutoRollbackStaleDeployment(t *testing.T) {
+	ctx := context.Background()
+
+	// the deployment of the previous commit failed
+	cluster := &fakeCluster{
+		jobs: map[string]*JobInfo{
+			"web": testJob("web", "abc"),
+		},
+		updates:           map[string]UpdateJobOptions{},
+		latestDeployments: map[string]string{"web": "failed"},
+		deployments: map[string]*DeploymentStatus{
+			"web": {Status: "successful"},
+		},
+	}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		clusterAccess: cluster,
+		evRepo:        &fakeEventRepo{},
+		notifier:      &fakeNotifier{},
+		statusPatcher: &fakeStatusPatcher{},
+	}
+	src := &domain.Source{ID: "src", AutoRollback: true}
+	desired := &DesiredState{
+		GitInfo: GitInfo{GitCommit: "def"},
+		Jobs: map[string]*JobInfo{
+			"web": testJob("web", "def"),
+		},
+	}
+
+	// the fix is not rolled back because of the failed deployment of the previous commit
+	_, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if len(cluster.reverted) != 0 || src.Status.RolledBackCommit != "" {
+		t.Fatalf("Expected the new commit to be kept, got %v - %+v", cluster.reverted, src.Status)
+	}
+	if src.Status.Jobs["web"].DeploymentStatus != "successful" {
+		t.Fatalf("Expected the deployment of the new commit in the status, got %+v", src.Status.Jobs["web"])
+	}
+
+	// without waitForDeployment the deployment of the sync is still followed
+	cluster.deployments["web"] = &DeploymentStatus{Status: "failed"}
+	desired.GitInfo.GitCommit = "ghi"
+	_, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if strings.Join(cluster.reverted, ",") != "web" || src.Status.RolledBackCommit != "ghi" {
+		t.Errorf("Expected the failed deployment to be rolled back, got %v - %+v", cluster.reverted, src.Status)
+	}
+}
+
+func TestOnReconcileSyncWaves(t *testing.T) {
+	ctx := context.Background()
+
+	waveJob := func(name, wave string) *JobInfo {
+		j := testJob(name, "abc")
+		j.Meta = map[string]string{MetaKeySyncWave: wave}
+		return j
+	}
+
+	cluster := &fakeCluster{
+		jobs: map[string]*JobInfo{
+			"old-db":  waveJob("old-db", "1"),
+			"old-api": waveJob("old-api", "2"),
+		},
+		updates: map[string]UpdateJobOptions{},
+		deployments: map[string]*DeploymentStatus{
+			"web": {Status: "successful"},
+			"db":  {Status: "successful"},
+		},
+	}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		clusterAccess: cluster,
+		evRepo:        &fakeEventRepo{},
+		notifier:      &fakeNotifier{},
+		statusPatcher: &fakeStatusPatcher{},
+	}
+	src := &domain.Source{ID: "src"}
+	desired := &DesiredState{
+		GitInfo: GitInfo{GitCommit: "abc"},
+		Jobs: map[string]*JobInfo{
+			"api": waveJob("api", "2"),
+			"db":  waveJob("db", "1"),
+			"web": testJob("web", "abc"),
+		},
+	}
+
+	_, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{ConfirmPrune: []string{"old-api", "old-db"}})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if strings.Join(cluster.order, ",") != "web,db,api" {
+		t.Fatalf("Expected jobs to be synced in wave order, got %v", cluster.order)
+	}
+	if strings.Join(cluster.deleted, ",") != "old-api,old-db" {
+		t.Fatalf("Expected jobs to be pruned in reverse wave order, got %v", cluster.deleted)
+	}
+
+	// a failed wave stops the later ones
+	cluster.order = nil
+	cluster.deployments["db"] = &DeploymentStatus{Status: "failed"}
+	desired.GitInfo.GitCommit = "def"
+	_, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if strings.Join(cluster.order, ",") != "web,db" {
+		t.Fatalf("Expected later waves to be skipped, got %v", cluster.order)
+	}
+	if src.Status.Status != domain.SourceStatusStatusSyncedWithError {
+		t.Errorf("Expected status %s, got %s", domain.SourceStatusStatusSyncedWithError, src.Status.Status)
+	}
+
+	// the next sync does not change the failed wave and still skips the later ones
+	for _, deployment := range []string{"failed", "running"} {
+		cluster.order = nil
+		cluster.upToDate = map[string]bool{"web": true, "db": true}
+		cluster.latestDeployments = map[string]string{"web": "successful", "db": deployment}
+		_, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+		if err != nil {
+			t.Fatalf("Could not reconcile:%v", err)
+		}
+		if strings.Join(cluster.order, ",") != "web,db" {
+			t.Fatalf("Expected later waves to be skipped while db is %s, got %v", deployment, cluster.order)
+		}
+	}
+	cluster.latestDeployments["db"] = "successful"
+	cluster.order = nil
+	_, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if strings.Join(cluster.order, ",") != "web,db,api" {
+		t.Fatalf("Expected later waves to be synced once db is healthy, got %v", cluster.order)
+	}
+
+	// an invalid wave fails before anything is pruned
+	cluster.deleted = nil
+	desired.Jobs["api"].Meta[MetaKeySyncWave] = "first"
+	_, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{ConfirmPrune: []string{"old-api", "old-db"}})
+	if err == nil {
+		t.Errorf("Expected an invalid sync wave to fail")
+	}
+	if len(cluster.deleted) != 0 {
+		t.Errorf("Expected no jobs to be pruned, got %v", cluster.deleted)
+	}
+}
+
+func TestOnReconcileApprovalBlockedByWindow(t *testing.T) {
+	ctx := context.Background()
+
+	cluster := &fakeCluster{
+		jobs:    map[string]*JobInfo{},
+		updates: map[string]UpdateJobOptions{},
+	}
+	evRepo := &fakeEventRepo{}
+	notifier := &fakeNotifier{}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		clusterAccess: cluster,
+		evRepo:        evRepo,
+		notifier:      notifier,
+	}
+	src := &domain.Source{ID: "src", RequireApproval: true}
+	desired := &DesiredState{
+		GitInfo: GitInfo{GitCommit: "abc"},
+		Jobs: map[string]*JobInfo{
+			"web": testJob("web", "abc"),
+		},
+	}
+
+	// plans do not wait for approval
+	_, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if src.Status.PendingApprovalCommit != "" || len(notifier.messages) != 0 {
+		t.Fatalf("Expected a plan not to wait for approval, got %+v", src.Status)
+	}
+
+	// the commit waits for approval while the source is blocked
+	for i := 0; i < 2; i++ {
+		_, err := r.OnReconcile(ctx, src, desired, SyncSourceOptions{BlockedByWindow: true})
+		if err != nil {
+			t.Fatalf("Could not reconcile:%v", err)
+		}
+		if !cluster.updates["web"].DryRun || src.Status.PendingApprovalCommit != "abc" {
+			t.Fatalf("Expected commit abc to wait for approval, got %+v", src.Status)
+		}
+	}
+	if len(notifier.messages) != 1 {
+		t.Fatalf("Expected a single notification about the pending approval, got %v", notifier.messages)
+	}
+
+	// approved during the freeze, applied once the window opens
+	_, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{BlockedByWindow: true, ApproveCommit: "abc", ReviewedBy: "jane"})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if !cluster.updates["web"].DryRun || src.Status.ApprovedCommit != "abc" || src.Status.PendingApprovalCommit != "" {
+		t.Fatalf("Expected commit abc to be approved but not applied, got %+v", src.Status)
+	}
+	_, err = r.OnReconcile(ctx, src, desired, SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if cluster.updates["web"].DryRun {
+		t.Fatalf("Expected commit abc to be applied")
+	}
+}
+
+func TestOnReconcileSyncWindow(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name       string
+		opts       SyncSourceOptions
+		wantDryRun bool
+		wantEvents []domain.EventType
+	}{
+		{name: "blocked", opts: SyncSourceOptions{BlockedByWindow: true}, wantDryRun: true},
+		{name: "overridden", opts: SyncSourceOptions{OverrideSyncWindowBy: "jane@example.com"},
+			wantEvents: []domain.EventType{domain.EventTypeWindowOverridden, domain.EventTypeCreated}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := &fakeCluster{
+				jobs:    map[string]*JobInfo{},
+				updates: map[string]UpdateJobOptions{},
+			}
+			evRepo := &fakeEventRepo{}
+			r := &ReconciliationManager{
+				logger:        log.NewSimpleLogger(false, "Test"),
+				clusterAccess: cluster,
+				evRepo:        evRepo,
+				notifier:      &fakeNotifier{},
+			}
+			changed, err := r.OnReconcile(ctx, &domain.Source{ID: "src"}, &DesiredState{
+				GitInfo: GitInfo{GitCommit: "abc"},
+				Jobs: map[string]*JobInfo{
+					"web": testJob("web", "abc"),
+				},
+			}, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not reconcile:%v", err)
+			}
+			if changed.DryRun != tc.wantDryRun || cluster.updates["web"].DryRun != tc.wantDryRun {
+				t.Fatalf("Expected dry run %v, got %v", tc.wantDryRun, cluster.updates["web"].DryRun)
+			}
+			if len(changed.Create) != 1 {
+				t.Fatalf("Expected web to be planned for creation, got %v", changed.Create)
+			}
+			var events []domain.EventType
+			for _, ev := range evRepo.events {
+				events = append(events, ev.Type)
+			}
+			if fmt.Sprint(events) != fmt.Sprint(tc.wantEvents) {
+				t.Errorf("Expected events %v, got %v", tc.wantEvents, events)
+			}
+			if len(evRepo.events) > 0 && evRepo.events[0].Message != "Sync window overridden by jane@example.com" {
+				t.Errorf("Unexpected event message %s", evRepo.events[0].Message)
+			}
+		})
+	}
+}
+
+func TestOnReconcileApproval(t *testing.T) {
+	ctx := context.Background()
+
+	cluster := &fakeCluster{
+		jobs:    map[string]*JobInfo{},
+		updates: map[string]UpdateJobOptions{},
+	}
+	evRepo := &fakeEventRepo{}
+	notifier := &fakeNotifier{}
+	r := &ReconciliationManager{
+		logger:        log.NewSimpleLogger(false, "Test"),
+		clusterAccess: cluster,
+		evRepo:        evRepo,
+		notifier:      notifier,
+	}
+	src := &domain.Source{ID: "src", RequireApproval: true}
+	desired := func(commit string) *DesiredState {
+		return &DesiredState{
+			GitInfo: GitInfo{GitCommit: commit},
+			Jobs: map[string]*JobInfo{
+				"web": testJob("web", commit),
+			},
+		}
+	}
+
+	// a new commit is only planned
+	for i := 0; i < 2; i++ {
+		_, err := r.OnReconcile(ctx, src, desired("abc"), SyncSourceOptions{})
+		if err != nil {
+			t.Fatalf("Could not reconcile:%v", err)
+		}
+	}
+	if !cluster.updates["web"].DryRun || src.Status.Status != domain.SourceStatusStatusPendingApproval {
+		t.Fatalf("Expected commit abc to wait for approval, got %s", src.Status.Status)
+	}
+	if src.Status.PendingApprovalCommit != "abc" || src.Status.PendingChanges == nil || src.Status.PendingChanges.Create[0] != "web" {
+		t.Fatalf("Expected web to be pending, got %+v", src.Status)
+	}
+	if len(notifier.messages) != 1 {
+		t.Fatalf("Expected a single notification about the pending approval, got %v", notifier.messages)
+	}
+
+	// approving applies the changes
+	_, err := r.OnReconcile(ctx, src, desired("abc"), SyncSourceOptions{ApproveCommit: "abc", ReviewedBy: "jane"})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if cluster.updates["web"].DryRun || src.Status.ApprovedCommit != "abc" || src.Status.PendingApprovalCommit != "" {
+		t.Fatalf("Expected commit abc to be applied, got %+v", src.Status)
+	}
+	if evRepo.events[0].Type != domain.EventTypeApproved || evRepo.events[0].Message != "Commit abc approved by jane" {
+		t.Errorf("Unexpected event %+v", evRepo.events[0])
+	}
+
+	// a rejected commit is not synced until a new one arrives
+	_, err = r.OnReconcile(ctx, src, desired("def"), SyncSourceOptions{RejectCommit: "def", ReviewedBy: "jane"})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if src.Status.RejectedCommit != "def" || src.Status.Status != domain.SourceStatusStatusOutOfSync {
+		t.Fatalf("Expected commit def to be rejected, got %+v", src.Status)
+	}
+	updates := len(cluster.order)
+	_, err = r.OnReconcile(ctx, src, desired("def"), SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if len(cluster.order) != updates {
+		t.Fatalf("Expected the rejected commit not to be planned again")
+	}
+	_, err = r.OnReconcile(ctx, src, desired("ghi"), SyncSourceOptions{})
+	if err != nil {
+		t.Fatalf("Could not reconcile:%v", err)
+	}
+	if src.Status.RejectedCommit != "" || src.Status.PendingApprovalCommit != "ghi" {
+		t.Errorf("Expected commit ghi to wait for approval, got %+v", src.Status)
+	}
+}
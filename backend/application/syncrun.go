@@ -0,0 +1,99 @@
+package application
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+)
+
+type SyncRunRepo interface {
+	SaveSyncRun(ctx context.Context, run *domain.SyncRun) error
+}
+
+type ListSyncRunsOptions struct {
+	SourceID string
+	// only runs that were running between Since and Until
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// saveSyncRun finishes the run with the outcome of the reconcile and records it
+func (w *RepoWatcher) saveSyncRun(ctx context.Context,
+	src *domain.Source,
+	run *domain.SyncRun,
+	changeInfo *ChangeInfo,
+	runErr error) {
+
+	run.FinishedAt = time.Now()
+	if runErr != nil {
+		run.Result = domain.SourceStatusStatusError
+		run.Error = runErr.Error()
+	} else {
+		run.Result = src.Status.Status
+		run.Message = src.Status.Message
+		run.DryRun = changeInfo.DryRun
+		run.Jobs = syncRunJobs(src.Status, changeInfo)
+		if w.cfg.SkipUnchangedSyncRuns && len(run.Jobs) == 0 && run.Result != domain.SourceStatusStatusError {
+			return
+		}
+	}
+
+	err := w.syncRunRepo.SaveSyncRun(ctx, run)
+	if err != nil {
+		w.logger.LogError(ctx, "Could not SaveSyncRun for %s:%v", src.ID, err)
+	}
+}
+
+// syncRunJobs lists the jobs the reconcile changed or planned, drifted and unmanaged jobs
+func syncRunJobs(status *domain.SourceStatus, changeInfo *ChangeInfo) []domain.SyncRunJob {
+	res := []domain.SyncRunJob{}
+	add := func(action domain.JobAction, jobs map[string]*JobInfo) {
+		for name, job := range jobs {
+			j := domain.SyncRunJob{
+				Name:      name,
+				Namespace: strPtrToStr(job.Namespace),
+				Action:    action,
+			}
+			if jobStatus, ok := status.Jobs[name]; ok {
+				j.Diff = jobStatus.Diff
+				if jobStatus.DeploymentStatus == "failed" {
+					j.Error = "Deployment failed"
+				}
+			}
+			res = append(res, j)
+		}
+	}
+	add(domain.JobActionCreate, changeInfo.Create)
+	add(domain.JobActionUpdate, changeInfo.Update)
+	add(domain.JobActionDelete, changeInfo.Delete)
+
+	for name, jobStatus := range status.Jobs {
+		action := domain.JobAction("")
+		if jobStatus.Drifted {
+			action = domain.JobActionDrifted
+		}
+		if jobStatus.Unmanaged {
+			action = domain.JobActionUnmanaged
+		}
+		if action == "" {
+			continue
+		}
+		res = append(res, domain.SyncRunJob{
+			Name:      name,
+			Namespace: jobStatus.Namespace,
+			Action:    action,
+			Diff:      jobStatus.Diff,
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].Action < res[j].Action
+	})
+	return res
+}
@@ -0,0 +1,103 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+type fakeSyncRunRepo struct {
+	runs []*domain.SyncRun
+}
+
+func (r *fakeSyncRunRepo) SaveSyncRun(ctx context.Context, run *domain.SyncRun) error {
+	r.runs = append(r.runs, run)
+	return nil
+}
+
+func TestSyncRunJobs(t *testing.T) {
+	status := &domain.SourceStatus{
+		Jobs: map[string]domain.JobStatus{
+			"web":    {Diff: []byte(`{"Type":"Edited"}`), DeploymentStatus: "failed"},
+			"db":     {Drifted: true},
+			"legacy": {Unmanaged: true, Namespace: "ops"},
+			"api":    {},
+		},
+	}
+	changeInfo := &ChangeInfo{
+		Create: map[string]*JobInfo{"worker": testJob("worker", "abc")},
+		Update: map[string]*JobInfo{"web": testJob("web", "abc")},
+		Delete: map[string]*JobInfo{},
+	}
+
+	jobs := syncRunJobs(status, changeInfo)
+	if len(jobs) != 4 {
+		t.Fatalf("Expected 4 jobs, got %d", len(jobs))
+	}
+	expected := []domain.SyncRunJob{
+		{Name: "db", Action: domain.JobActionDrifted},
+		{Name: "legacy", Namespace: "ops", Action: domain.JobActionUnmanaged},
+		{Name: "web", Namespace: "default", Action: domain.JobActionUpdate, Error: "Deployment failed"},
+		{Name: "worker", Namespace: "default", Action: domain.JobActionCreate},
+	}
+	for i, e := range expected {
+		if jobs[i].Name != e.Name || jobs[i].Namespace != e.Namespace || jobs[i].Action != e.Action || jobs[i].Error != e.Error {
+			t.Errorf("Unexpected job %d: %+v", i, jobs[i])
+		}
+	}
+	if string(jobs[2].Diff) != `{"Type":"Edited"}` {
+		t.Errorf("Expected the diff of the status, got %s", jobs[2].Diff)
+	}
+}
+
+func TestSaveSyncRun(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeSyncRunRepo{}
+	w, err := CreateRepoWatcher(ctx, log.NewSimpleLogger(false, "Test"), RepoWatcherConfig{
+		AppName:               "test",
+		SkipUnchangedSyncRuns: true,
+	}, nil, nil, nil, nil, nil, repo)
+	if err != nil {
+		t.Fatalf("Could not CreateRepoWatcher:%v", err)
+	}
+
+	src := &domain.Source{
+		ID: "src",
+		Status: &domain.SourceStatus{
+			Status: domain.SourceStatusStatusSynced,
+			Jobs:   map[string]domain.JobStatus{},
+		},
+	}
+	unchanged := &ChangeInfo{}
+	w.saveSyncRun(ctx, src, &domain.SyncRun{SourceID: src.ID}, unchanged, nil)
+	if len(repo.runs) != 0 {
+		t.Fatalf("Expected unchanged runs to be skipped")
+	}
+
+	// errors are always recorded
+	w.saveSyncRun(ctx, src, &domain.SyncRun{SourceID: src.ID}, nil, fmt.Errorf("fetch failed"))
+	if len(repo.runs) != 1 || repo.runs[0].Result != domain.SourceStatusStatusError || repo.runs[0].Error != "fetch failed" {
+		t.Fatalf("Expected an error run, got %+v", repo.runs)
+	}
+	src.Status.Status = domain.SourceStatusStatusError
+	w.saveSyncRun(ctx, src, &domain.SyncRun{SourceID: src.ID}, unchanged, nil)
+	if len(repo.runs) != 2 || repo.runs[1].Result != domain.SourceStatusStatusError {
+		t.Fatalf("Expected the failed run to be recorded, got %+v", repo.runs)
+	}
+
+	src.Status.Status = domain.SourceStatusStatusOutOfSync
+	w.saveSyncRun(ctx, src, &domain.SyncRun{SourceID: src.ID}, &ChangeInfo{
+		DryRun: true,
+		Create: map[string]*JobInfo{"web": testJob("web", "abc")},
+	}, nil)
+	if len(repo.runs) != 3 {
+		t.Fatalf("Expected the changed run to be recorded")
+	}
+	run := repo.runs[2]
+	if !run.DryRun || run.Result != domain.SourceStatusStatusOutOfSync || len(run.Jobs) != 1 || run.FinishedAt.IsZero() {
+		t.Errorf("Unexpected run: %+v", run)
+	}
+}
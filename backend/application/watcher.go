@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +19,8 @@ import (
 
 type DesiredStateWatcher interface {
 	FetchDesiredState(ctx context.Context, src *domain.Source) (*DesiredState, error)
+	// ReleaseSource frees all resources (e.g. cached repos) held for the source
+	ReleaseSource(ctx context.Context, id string) error
 }
 
 type WatchInfo struct {
@@ -41,12 +44,21 @@ type RepoWatcher struct {
 	watchList           map[string]*WatchInfo
 	notifier            Notifier
 	vaultRepo           VaultTokenRepo
+	teamRepo            TeamRepo
+	syncRunRepo         SyncRunRepo
+	streamLock          sync.Mutex
+	streamConnected     bool
+	streamChanged       chan struct{} // closed and replaced whenever streamConnected changes
 }
 
 type RepoWatcherConfig struct {
-	Interval        time.Duration
-	ErrorRetryCount int
-	AppName         string
+	Interval time.Duration
+	// used instead of Interval while the nomad event stream is disconnected, if shorter
+	FallbackInterval time.Duration
+	ErrorRetryCount  int
+	AppName          string
+	// successful runs without any job are not recorded
+	SkipUnchangedSyncRuns bool
 }
 
 type SourceStatusPatcher interface {
@@ -59,7 +71,9 @@ func CreateRepoWatcher(ctx context.Context,
 	sourceStatusPatcher SourceStatusPatcher,
 	dsw DesiredStateWatcher,
 	notifier Notifier,
-	vaultRepo VaultTokenRepo) (*RepoWatcher, error) {
+	vaultRepo VaultTokenRepo,
+	teamRepo TeamRepo,
+	syncRunRepo SyncRunRepo) (*RepoWatcher, error) {
 	t := &RepoWatcher{
 		ctx:                 ctx,
 		logger:              logger,
@@ -69,13 +83,102 @@ func CreateRepoWatcher(ctx context.Context,
 		watchList:           map[string]*WatchInfo{},
 		notifier:            notifier,
 		vaultRepo:           vaultRepo,
+		teamRepo:            teamRepo,
+		syncRunRepo:         syncRunRepo,
+		streamChanged:       make(chan struct{}),
 	}
 
+	metrics.GetOrCreateGauge(fmt.Sprintf(`nomad_ops_event_stream_connected{app="%s"}`, cfg.AppName), func() float64 {
+		if t.IsEventStreamConnected() {
+			return 1
+		}
+		return 0
+	})
+
 	return t, nil
 }
 
+// SetEventStreamConnected records the state of the nomad event stream,
+// sources are polled with the FallbackInterval while it is disconnected
+func (w *RepoWatcher) SetEventStreamConnected(ctx context.Context, connected bool) {
+	w.streamLock.Lock()
+	defer w.streamLock.Unlock()
+	if w.streamConnected == connected {
+		return
+	}
+	if connected {
+		w.logger.LogInfo(ctx, "Nomad event stream connected, polling every %v", w.cfg.Interval)
+	} else {
+		w.logger.LogInfo(ctx, "Nomad event stream disconnected, polling every %v", w.pollIntervalLocked())
+	}
+	w.streamConnected = connected
+	close(w.streamChanged)
+	w.streamChanged = make(chan struct{})
+}
+
+func (w *RepoWatcher) IsEventStreamConnected() bool {
+	w.streamLock.Lock()
+	defer w.streamLock.Unlock()
+	return w.streamConnected
+}
+
+// pollIntervalLocked requires streamLock
+func (w *RepoWatcher) pollIntervalLocked() time.Duration {
+	if !w.streamConnected && w.cfg.FallbackInterval > 0 && w.cfg.FallbackInterval < w.cfg.Interval {
+		return w.cfg.FallbackInterval
+	}
+	return w.cfg.Interval
+}
+
+// waitForPoll returns a channel that fires once the poll interval passed since start.
+// The interval follows changes of the event stream state until ctx is done
+func (w *RepoWatcher) waitForPoll(ctx context.Context, start time.Time) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	go func() {
+		for {
+			w.streamLock.Lock()
+			interval := w.pollIntervalLocked()
+			changed := w.streamChanged
+			w.streamLock.Unlock()
+
+			select {
+			case t := <-time.After(time.Until(start.Add(interval))):
+				ch <- t
+				return
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
 type SyncSourceOptions struct {
 	ForceRestart bool
+	// names of the jobs held back by the prune mode or limit of the source that are pruned
+	ConfirmPrune []string
+	// names of existing jobs that are claimed by the source
+	Adopt []string
+	// only plans the changes, like a paused source
+	DryRun bool
+	// only plans the changes because the sync windows block the source,
+	// unlike DryRun a commit requiring approval still waits for and can receive it
+	BlockedByWindow bool
+	// syncs outside of the sync windows, the user is recorded in an event
+	OverrideSyncWindowBy string
+	// commit whose planned changes are approved or rejected
+	ApproveCommit string
+	RejectCommit  string
+	// user who approved or rejected the commit
+	ReviewedBy string
+	// pins the source to the commit until it is unpinned
+	PinCommit string
+	Unpin     bool
+	// user who pinned or unpinned the source
+	PinnedBy string
+	// recorded in the sync history, defaults to manual
+	Trigger domain.SyncTrigger
 }
 
 func (w *RepoWatcher) SyncSourceByID(ctx context.Context, id string, opts SyncSourceOptions) error {
@@ -95,10 +198,27 @@ func (w *RepoWatcher) SyncSourceByID(ctx context.Context, id string, opts SyncSo
 
 func (w *RepoWatcher) SyncSource(ctx context.Context, repo, branch string, opts SyncSourceOptions) error {
 	w.logger.LogInfo(ctx, "Syncing repo %s on branch %s", repo, branch)
+	srcs := w.ListWatchedSources(ctx, repo, "refs/heads/"+branch)
+	if len(srcs) == 0 {
+		return errors.ErrNotFound
+	}
+	for _, src := range srcs {
+		err := w.SyncSourceByID(ctx, src.ID, opts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListWatchedSources returns all watched sources that point to the given repo path (e.g. "org/repo")
+// and follow the given ref, e.g. refs/heads/main or refs/tags/v1.4.2
+func (w *RepoWatcher) ListWatchedSources(ctx context.Context, repo, ref string) []*domain.Source {
 	w.lock.Lock()
-	var wis []*WatchInfo
+	defer w.lock.Unlock()
+	var res []*domain.Source
 	for _, iwi := range w.watchList {
-		if iwi.Source.Branch != branch {
+		if !followsRef(iwi.Source, ref) {
 			continue
 		}
 
@@ -110,23 +230,36 @@ func (w *RepoWatcher) SyncSource(ctx context.Context, repo, branch string, opts
 		}
 		w.logger.LogTrace(ctx, "Raw Path: %s", u.RawPath)
 		w.logger.LogTrace(ctx, "Path: %s", u.Path)
-		if u.Path != fmt.Sprintf("%s.git", repo) {
+		if normalizeRepoPath(u.Path) != normalizeRepoPath(repo) {
 			continue
 		}
-		cpy := iwi
-		wis = append(wis, cpy)
-	}
-	w.lock.Unlock()
-	if len(wis) == 0 {
-		return errors.ErrNotFound
+		res = append(res, iwi.Source)
 	}
-	for _, wi := range wis {
-		err := wi.syncFunc(ctx, opts)
-		if err != nil {
-			return err
-		}
+	return res
+}
+
+// followsRef returns true if a push of the ref may change the revision of the source.
+// Pushed tags concern the source of the tag and every semver source, which resolve their newest
+// matching tag on sync. Commits are pinned and never change
+func followsRef(src *domain.Source, ref string) bool {
+	switch src.RevisionKind {
+	case "", domain.RevisionKindBranch:
+		return ref == "refs/heads/"+src.Branch
+	case domain.RevisionKindTag:
+		return ref == "refs/tags/"+src.Branch
+	case domain.RevisionKindSemver:
+		return strings.HasPrefix(ref, "refs/tags/")
+	default:
+		return false
 	}
-	return nil
+}
+
+// normalizeRepoPath strips leading slashes and the .git suffix,
+// as ssh and https urls of the same repo differ in those
+func normalizeRepoPath(p string) string {
+	p = strings.Trim(p, "/")
+	p = strings.TrimSuffix(p, ".git")
+	return strings.ToLower(p)
 }
 
 func (w *RepoWatcher) UpdateSource(ctx context.Context, src *domain.Source) error {
@@ -197,10 +330,12 @@ func (w *RepoWatcher) WatchSource(ctx context.Context, origSrc *domain.Source, c
 		},
 	}
 
-	err := w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, &domain.SourceStatus{
+	initStatus := &domain.SourceStatus{
 		Message: "Waiting on first sync",
 		Status:  domain.SourceStatusStatusInit,
-	})
+	}
+	keepCommits(wi.Source, initStatus)
+	err := w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, initStatus)
 	if err != nil {
 		w.logger.LogError(ctx, "Could not SetSourceStatus on %s:%v", wi.Source.ID, err)
 	}
@@ -243,8 +378,6 @@ func (w *RepoWatcher) WatchSource(ctx context.Context, origSrc *domain.Source, c
 					w.cfg.AppName)).Dec()
 		}()
 
-		waitTime := w.cfg.Interval
-
 		for {
 			select {
 			case <-wi.ctx.Done():
@@ -259,17 +392,31 @@ func (w *RepoWatcher) WatchSource(ctx context.Context, origSrc *domain.Source, c
 						wi.Source.DeployKeyID, wi.Source.Path, errorCount != 0)).Inc()
 			}
 			firstRun = false
-			restart := false
+			syncOpts := SyncSourceOptions{}
+			waitCtx, cancelWait := context.WithCancel(wi.ctx)
 			select {
-			case <-time.After(waitTime):
+			case <-w.waitForPoll(waitCtx, time.Now()):
+				syncOpts.Trigger = domain.SyncTriggerPoll
 			case opts := <-wi.syncCh:
-				restart = opts.ForceRestart
+				syncOpts = opts
+				if syncOpts.Trigger == "" {
+					syncOpts.Trigger = domain.SyncTriggerManual
+				}
 			case src := <-wi.updateCh:
 				w.logger.LogInfo(wi.ctx, "Updating watch on %s %s - %s", wi.Source.Name, wi.Source.URL, wi.Source.Path)
 				wi.Source = src
+				syncOpts.Trigger = domain.SyncTriggerSourceUpdate
 			case <-wi.ctx.Done():
+				cancelWait()
 				return
 			}
+			cancelWait()
+			restart := syncOpts.ForceRestart
+			run := &domain.SyncRun{
+				SourceID:  wi.Source.ID,
+				StartedAt: time.Now(),
+				Trigger:   syncOpts.Trigger,
+			}
 			wi.Source.Status.Status = domain.SourceStatusStatusSyncing
 			wi.Source.Status.Message = "Syncing"
 
@@ -278,14 +425,19 @@ func (w *RepoWatcher) WatchSource(ctx context.Context, origSrc *domain.Source, c
 				w.logger.LogError(ctx, "Could not SetSourceStatus on %s:%v", wi.Source.ID, err)
 			}
 
-			desiredState, err := w.dsw.FetchDesiredState(wi.ctx, wi.Source)
+			pinnedCommit := wi.Source.Status.PinnedCommit
+			if syncOpts.PinCommit != "" {
+				pinnedCommit = syncOpts.PinCommit
+			}
+			if syncOpts.Unpin {
+				pinnedCommit = ""
+			}
+
+			desiredState, err := w.dsw.FetchDesiredState(wi.ctx, pinnedSource(wi.Source, pinnedCommit))
 			if err != nil {
 				w.logger.LogError(wi.ctx, "Could not FetchDesiredState: %v - %v - %v", err, wi.Source.URL, wi.Source.Path)
-				err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, &domain.SourceStatus{
-					Status:        domain.SourceStatusStatusError,
-					Message:       err.Error(),
-					LastCheckTime: toTimePtr(time.Now()),
-				})
+				w.saveSyncRun(wi.ctx, wi.Source, run, nil, err)
+				err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, errorStatus(wi.Source, err))
 				if err != nil {
 					w.logger.LogError(ctx, "Could not SetSourceStatus on %s:%v", wi.Source.ID, err)
 				}
@@ -338,15 +490,24 @@ func (w *RepoWatcher) WatchSource(ctx context.Context, origSrc *domain.Source, c
 				continue
 			}
 
+			run.GitCommit = desiredState.GitInfo.GitCommit
+			run.GitRef = desiredState.GitInfo.GitRef
+
+			// the pin changes only once its commit could be fetched
+			if pinnedCommit != wi.Source.Status.PinnedCommit {
+				wi.Source.Status.PinnedCommit = pinnedCommit
+				wi.Source.Status.PinnedBy = ""
+				if pinnedCommit != "" {
+					wi.Source.Status.PinnedBy = syncOpts.PinnedBy
+				}
+			}
+
 			if wi.Source.VaultTokenID != "" {
 				t, err := w.vaultRepo.GetVaultToken(ctx, wi.Source.VaultTokenID)
 				if err != nil {
 					w.logger.LogError(wi.ctx, "Could not GetVaultToken: %v - %v - %v", err, wi.Source.URL, wi.Source.Path)
-					err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, &domain.SourceStatus{
-						Status:        domain.SourceStatusStatusError,
-						Message:       err.Error(),
-						LastCheckTime: toTimePtr(time.Now()),
-					})
+					w.saveSyncRun(wi.ctx, wi.Source, run, nil, err)
+					err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, errorStatus(wi.Source, err))
 					if err != nil {
 						w.logger.LogError(ctx, "Could not SetSourceStatus on %s:%v", wi.Source.ID, err)
 					}
@@ -404,11 +565,8 @@ func (w *RepoWatcher) WatchSource(ctx context.Context, origSrc *domain.Source, c
 			err = w.applyOverrides(wi.ctx, wi.Source, desiredState)
 			if err != nil {
 				w.logger.LogError(wi.ctx, "Could not apply overrides: %v - %v - %v", err, wi.Source.URL, wi.Source.Path)
-				err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, &domain.SourceStatus{
-					Status:        domain.SourceStatusStatusError,
-					Message:       err.Error(),
-					LastCheckTime: toTimePtr(time.Now()),
-				})
+				w.saveSyncRun(wi.ctx, wi.Source, run, nil, err)
+				err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, errorStatus(wi.Source, err))
 				if err != nil {
 					w.logger.LogError(ctx, "Could not SetSourceStatus on %s:%v", wi.Source.ID, err)
 				}
@@ -458,14 +616,32 @@ func (w *RepoWatcher) WatchSource(ctx context.Context, origSrc *domain.Source, c
 				continue
 			}
 
-			changeInfo, err := wi.Reconciler(wi.ctx, wi.Source, desiredState, restart)
+			allowed, err := w.syncAllowed(wi.ctx, wi.Source, time.Now())
+			if err != nil {
+				w.logger.LogError(wi.ctx, "Could not check sync windows: %v - %v - %v", err, wi.Source.URL, wi.Source.Path)
+				w.saveSyncRun(wi.ctx, wi.Source, run, nil, err)
+				err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, errorStatus(wi.Source, err))
+				if err != nil {
+					w.logger.LogError(ctx, "Could not SetSourceStatus on %s:%v", wi.Source.ID, err)
+				}
+				errorCount++
+				continue
+			}
+			blocked := false
+			if allowed {
+				syncOpts.OverrideSyncWindowBy = ""
+			} else if syncOpts.OverrideSyncWindowBy != "" {
+				w.logger.LogInfo(wi.ctx, "Sync window of %s overridden by %s", wi.Source.ID, syncOpts.OverrideSyncWindowBy)
+			} else {
+				blocked = !wi.Source.Paused
+				syncOpts.BlockedByWindow = true
+			}
+
+			changeInfo, err := wi.Reconciler(wi.ctx, wi.Source, desiredState, syncOpts)
 			if err != nil {
 				w.logger.LogError(wi.ctx, "Could not Reconcile: %v - %v - %v", err, wi.Source.URL, wi.Source.Path)
-				err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, &domain.SourceStatus{
-					Status:        domain.SourceStatusStatusError,
-					Message:       err.Error(),
-					LastCheckTime: toTimePtr(time.Now()),
-				})
+				w.saveSyncRun(wi.ctx, wi.Source, run, nil, err)
+				err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, errorStatus(wi.Source, err))
 				if err != nil {
 					w.logger.LogError(ctx, "Could not SetSourceStatus on %s:%v", wi.Source.ID, err)
 				}
@@ -565,29 +741,108 @@ func (w *RepoWatcher) WatchSource(ctx context.Context, origSrc *domain.Source, c
 				}
 			}
 
-			if wi.Source.Paused {
+			// a commit waiting for approval keeps its planned changes and message
+			if (wi.Source.Paused || blocked) && wi.Source.Status.PendingApprovalCommit == "" {
 				wi.Source.Status.Status = domain.SourceStatusStatusSynced
 				msg := "Still in sync"
 				if len(changeInfo.Create) > 0 || len(changeInfo.Update) > 0 || len(changeInfo.Delete) > 0 {
 					msg = fmt.Sprintf("Out of sync: %d to create, %d to update, %d to delete",
 						len(changeInfo.Create), len(changeInfo.Update), len(changeInfo.Delete))
 					wi.Source.Status.Status = domain.SourceStatusStatusOutOfSync
+					wi.Source.Status.PendingChanges = pendingChanges(changeInfo)
 				}
 				wi.Source.Status.Message = msg
 			}
+			if blocked {
+				wi.Source.Status.Status = domain.SourceStatusStatusBlockedByWindow
+				wi.Source.Status.Message = "Outside of the sync windows. " + wi.Source.Status.Message
+			}
 
 			wi.Source.Status.DetermineSyncStatus()
 
+			if wi.Source.Status.PinnedCommit != "" && wi.Source.Status.Message == "" {
+				wi.Source.Status.Message = fmt.Sprintf("Pinned to commit %s", wi.Source.Status.PinnedCommit)
+			}
+
 			err = w.sourceStatusPatcher.SetSourceStatus(workerCtx, wi.Source, wi.Source.Status)
 			if err != nil {
 				w.logger.LogError(ctx, "Could not SetSourceStatus on %s:%v", wi.Source.ID, err)
 			}
+			w.saveSyncRun(wi.ctx, wi.Source, run, changeInfo, nil)
 		}
 	}(wi)
 
 	return nil
 }
 
+// syncAllowed checks the sync windows of the source and its teams
+func (w *RepoWatcher) syncAllowed(ctx context.Context, src *domain.Source, t time.Time) (bool, error) {
+	windows := src.SyncWindows
+	for _, id := range src.TeamIDs {
+		team, err := w.teamRepo.GetTeam(ctx, id)
+		if err == errors.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		windows = append(windows, team.SyncWindows...)
+	}
+	return domain.SyncAllowed(windows, t)
+}
+
+// pendingChanges lists the jobs of a dry run
+func pendingChanges(changeInfo *ChangeInfo) *domain.PendingChanges {
+	res := &domain.PendingChanges{}
+	for k := range changeInfo.Create {
+		res.Create = append(res.Create, k)
+	}
+	for k := range changeInfo.Update {
+		res.Update = append(res.Update, k)
+	}
+	for k := range changeInfo.Delete {
+		res.Delete = append(res.Delete, k)
+	}
+	sort.Strings(res.Create)
+	sort.Strings(res.Update)
+	sort.Strings(res.Delete)
+	return res
+}
+
+// errorStatus replaces the status of a source with an error, keeping the rolled back, approved, rejected and pinned commits
+func errorStatus(src *domain.Source, err error) *domain.SourceStatus {
+	s := &domain.SourceStatus{
+		Status:        domain.SourceStatusStatusError,
+		Message:       err.Error(),
+		LastCheckTime: toTimePtr(time.Now()),
+	}
+	keepCommits(src, s)
+	return s
+}
+
+// keepCommits copies the commits that outlive a status reset
+func keepCommits(src *domain.Source, s *domain.SourceStatus) {
+	if src.Status == nil {
+		return
+	}
+	s.RolledBackCommit = src.Status.RolledBackCommit
+	s.ApprovedCommit = src.Status.ApprovedCommit
+	s.RejectedCommit = src.Status.RejectedCommit
+	s.PinnedCommit = src.Status.PinnedCommit
+	s.PinnedBy = src.Status.PinnedBy
+}
+
+// pinnedSource returns a copy of the source pointing to the pinned commit, or the source if it is not pinned
+func pinnedSource(src *domain.Source, commit string) *domain.Source {
+	if commit == "" {
+		return src
+	}
+	cpy := *src
+	cpy.RevisionKind = domain.RevisionKindCommit
+	cpy.Branch = commit
+	return &cpy
+}
+
 func (w *RepoWatcher) StopSourceWatch(ctx context.Context, id string) error {
 	w.lock.Lock()
 	defer w.lock.Unlock()
@@ -601,3 +856,12 @@ func (w *RepoWatcher) StopSourceWatch(ctx context.Context, id string) error {
 
 	return nil
 }
+
+// RemoveSource stops the watch and releases everything held for the deleted source
+func (w *RepoWatcher) RemoveSource(ctx context.Context, id string) error {
+	err := w.StopSourceWatch(ctx, id)
+	if err != nil {
+		return err
+	}
+	return w.dsw.ReleaseSource(ctx, id)
+}
@@ -0,0 +1,180 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/errors"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+func TestWaitForPoll(t *testing.T) {
+	ctx := context.Background()
+	w, err := CreateRepoWatcher(ctx, log.NewSimpleLogger(false, "Test"), RepoWatcherConfig{
+		Interval:         time.Hour,
+		FallbackInterval: 50 * time.Millisecond,
+		AppName:          "test",
+	}, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateRepoWatcher:%v", err)
+	}
+
+	w.SetEventStreamConnected(ctx, true)
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := w.waitForPoll(waitCtx, time.Now())
+	select {
+	case <-ch:
+		t.Fatalf("Expected the regular interval while connected")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a disconnect shortens the running wait
+	w.SetEventStreamConnected(ctx, false)
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the fallback interval while disconnected")
+	}
+}
+
+type fakeTeamRepo struct {
+	teams map[string]*domain.Team
+}
+
+func (r *fakeTeamRepo) GetTeam(ctx context.Context, id string) (*domain.Team, error) {
+	t, ok := r.teams[id]
+	if !ok {
+		return nil, errors.ErrNotFound
+	}
+	return t, nil
+}
+
+func TestSyncAllowed(t *testing.T) {
+	ctx := context.Background()
+	w := &RepoWatcher{
+		teamRepo: &fakeTeamRepo{
+			teams: map[string]*domain.Team{
+				"ops": {
+					ID: "ops",
+					SyncWindows: []domain.SyncWindow{
+						// weekend freeze from friday 18:00
+						{Kind: domain.SyncWindowDeny, Schedule: "0 18 * * 5", Duration: "54h"},
+					},
+				},
+			},
+		},
+	}
+	businessHours := []domain.SyncWindow{
+		{Kind: domain.SyncWindowAllow, Schedule: "0 8 * * 1-5", Duration: "10h"},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		src     *domain.Source
+		at      string
+		allowed bool
+		wantErr bool
+	}{
+		{name: "no windows", src: &domain.Source{}, at: "2024-06-08T12:00:00Z", allowed: true},
+		{name: "inside allow", src: &domain.Source{SyncWindows: businessHours}, at: "2024-06-04T09:00:00Z", allowed: true},
+		{name: "outside allow", src: &domain.Source{SyncWindows: businessHours}, at: "2024-06-04T19:00:00Z", allowed: false},
+		{name: "team deny", src: &domain.Source{TeamIDs: []string{"ops", "gone"}}, at: "2024-06-08T12:00:00Z", allowed: false},
+		{name: "team deny ended", src: &domain.Source{TeamIDs: []string{"ops"}}, at: "2024-06-10T01:00:00Z", allowed: true},
+		{name: "deny wins", src: &domain.Source{SyncWindows: businessHours, TeamIDs: []string{"ops"}}, at: "2024-06-07T19:00:00Z", allowed: false},
+		{name: "time zone", src: &domain.Source{SyncWindows: []domain.SyncWindow{
+			{Kind: domain.SyncWindowAllow, Schedule: "0 8 * * *", Duration: "1h", TimeZone: "Europe/Berlin"},
+		}}, at: "2024-06-04T06:30:00Z", allowed: true},
+		{name: "invalid schedule", src: &domain.Source{SyncWindows: []domain.SyncWindow{
+			{Kind: domain.SyncWindowDeny, Schedule: "every day", Duration: "1h"},
+		}}, at: "2024-06-04T06:30:00Z", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			at, err := time.Parse(time.RFC3339, tc.at)
+			if err != nil {
+				t.Fatalf("Could not parse time:%v", err)
+			}
+			allowed, err := w.syncAllowed(ctx, tc.src, at)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Could not check sync windows:%v", err)
+			}
+			if allowed != tc.allowed {
+				t.Errorf("Expected allowed %v, got %v", tc.allowed, allowed)
+			}
+		})
+	}
+}
+
+func TestPinnedSource(t *testing.T) {
+	src := &domain.Source{
+		Branch:       "main",
+		RevisionKind: domain.RevisionKindBranch,
+		Status: &domain.SourceStatus{
+			PinnedCommit: "0123456789abcdef0123456789abcdef01234567",
+			PinnedBy:     "jane",
+		},
+	}
+	if pinnedSource(src, "") != src {
+		t.Fatalf("Expected an unpinned source to be used as is")
+	}
+	pinned := pinnedSource(src, src.Status.PinnedCommit)
+	if pinned.RevisionKind != domain.RevisionKindCommit || pinned.Branch != src.Status.PinnedCommit {
+		t.Fatalf("Expected the pinned commit to be fetched, got %s %s", pinned.RevisionKind, pinned.Branch)
+	}
+	if src.Branch != "main" {
+		t.Fatalf("Expected the source to be unchanged")
+	}
+
+	s := errorStatus(src, errors.ErrNotFound)
+	if s.PinnedCommit != src.Status.PinnedCommit || s.PinnedBy != "jane" {
+		t.Errorf("Expected the pin to survive an error, got %+v", s)
+	}
+}
+
+func TestListWatchedSources(t *testing.T) {
+	ctx := context.Background()
+	w, err := CreateRepoWatcher(ctx, log.NewSimpleLogger(false, "Test"), RepoWatcherConfig{}, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateRepoWatcher:%v", err)
+	}
+	for _, src := range []*domain.Source{
+		{ID: "main", URL: "https://example.com/org/repo.git", Branch: "main"},
+		{ID: "dev", URL: "git@example.com:org/repo.git", Branch: "dev", RevisionKind: domain.RevisionKindBranch},
+		{ID: "tag", URL: "https://example.com/org/repo.git", Branch: "v1.0.0", RevisionKind: domain.RevisionKindTag},
+		{ID: "semver", URL: "https://example.com/org/repo.git", Branch: "v1.*", RevisionKind: domain.RevisionKindSemver},
+		{ID: "commit", URL: "https://example.com/org/repo.git", Branch: "0123456789abcdef0123456789abcdef01234567", RevisionKind: domain.RevisionKindCommit},
+		{ID: "other", URL: "https://example.com/org/other.git", Branch: "main"},
+	} {
+		w.watchList[src.ID] = &WatchInfo{Source: src}
+	}
+
+	for _, tc := range []struct {
+		ref  string
+		want []string
+	}{
+		{ref: "refs/heads/main", want: []string{"main"}},
+		{ref: "refs/heads/dev", want: []string{"dev"}},
+		{ref: "refs/tags/v1.0.0", want: []string{"semver", "tag"}},
+		{ref: "refs/tags/v2.0.0", want: []string{"semver"}},
+		{ref: "refs/heads/v1.0.0", want: nil},
+	} {
+		var ids []string
+		for _, src := range w.ListWatchedSources(ctx, "org/repo", tc.ref) {
+			ids = append(ids, src.ID)
+		}
+		sort.Strings(ids)
+		if fmt.Sprint(ids) != fmt.Sprint(tc.want) {
+			t.Errorf("Expected %v for %s, got %v", tc.want, tc.ref, ids)
+		}
+	}
+}
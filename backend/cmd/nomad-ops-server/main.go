@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"embed"
+	goerrors "errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,16 +24,22 @@ import (
 
 	"github.com/nomad-ops/nomad-ops/backend/application"
 	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/interfaces/credentialstore"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/eventstore"
+	"github.com/nomad-ops/nomad-ops/backend/interfaces/githooks"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/github"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/keystore"
+	"github.com/nomad-ops/nomad-ops/backend/interfaces/knownhoststore"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/nomadcluster"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/notifier"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/sourcestore"
+	"github.com/nomad-ops/nomad-ops/backend/interfaces/syncrunstore"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/teamstore"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/teamsync"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/userstore"
 	"github.com/nomad-ops/nomad-ops/backend/interfaces/vaulttokenstore"
+	"github.com/nomad-ops/nomad-ops/backend/interfaces/webhooksecretstore"
+	"github.com/nomad-ops/nomad-ops/backend/utils/debounce"
 	"github.com/nomad-ops/nomad-ops/backend/utils/env"
 	"github.com/nomad-ops/nomad-ops/backend/utils/errors"
 	"github.com/nomad-ops/nomad-ops/backend/utils/log"
@@ -198,6 +207,28 @@ func main() {
 			return err
 		}
 
+		credentialStore, err := credentialstore.CreatePocketBaseStore(ctx,
+			log.NewSimpleLogger(trace, "CredentialStore-PocketBase"),
+			credentialstore.PocketBaseStoreConfig{
+				App: e.App,
+			})
+
+		if err != nil {
+			logger.LogError(ctx, "Could not CreatePocketBaseStore for credentials:%v", err)
+			return err
+		}
+
+		knownHostStore, err := knownhoststore.CreatePocketBaseStore(ctx,
+			log.NewSimpleLogger(trace, "KnownHostStore-PocketBase"),
+			knownhoststore.PocketBaseStoreConfig{
+				App: e.App,
+			})
+
+		if err != nil {
+			logger.LogError(ctx, "Could not CreatePocketBaseStore for known hosts:%v", err)
+			return err
+		}
+
 		teamStore, err := teamstore.CreatePocketBaseStore(ctx,
 			log.NewSimpleLogger(trace, "TeamStore-PocketBase"),
 			teamstore.PocketBaseStoreConfig{
@@ -208,6 +239,16 @@ func main() {
 			logger.LogError(ctx, "Could not CreatePocketBaseStore for teams:%v", err)
 			return err
 		}
+		syncRunStore, err := syncrunstore.CreatePocketBaseStore(ctx,
+			log.NewSimpleLogger(trace, "SyncRunStore-PocketBase"),
+			syncrunstore.PocketBaseStoreConfig{
+				App: e.App,
+			})
+
+		if err != nil {
+			logger.LogError(ctx, "Could not CreatePocketBaseStore for sync runs:%v", err)
+			return err
+		}
 		vaultTokenStore, err := vaulttokenstore.CreatePocketBaseStore(ctx,
 			log.NewSimpleLogger(trace, "VaultTokenStore-PocketBase"),
 			vaulttokenstore.PocketBaseStoreConfig{
@@ -219,6 +260,17 @@ func main() {
 			return err
 		}
 
+		webhookSecretStore, err := webhooksecretstore.CreatePocketBaseStore(ctx,
+			log.NewSimpleLogger(trace, "WebhookSecretStore-PocketBase"),
+			webhooksecretstore.PocketBaseStoreConfig{
+				App: e.App,
+			})
+
+		if err != nil {
+			logger.LogError(ctx, "Could not CreatePocketBaseStore for webhookSecrets:%v", err)
+			return err
+		}
+
 		nomadToken := ""
 		if tokenPath := env.GetStringEnv(ctx, logger, "NOMAD_TOKEN_FILE", ""); tokenPath != "" {
 			logger.LogInfo(ctx, "Using NOMAD_TOKEN_FILE...")
@@ -243,10 +295,15 @@ func main() {
 		dsw, err := github.CreateGitProvider(ctx,
 			log.NewSimpleLogger(trace, "GitProvider"),
 			github.GitProviderConfig{
-				ReposDir: env.GetStringEnv(ctx, logger, "NOMAD_OPS_LOCAL_REPO_DIR", "repos"),
+				ReposDir:       env.GetStringEnv(ctx, logger, "NOMAD_OPS_LOCAL_REPO_DIR", "repos"),
+				Persist:        env.GetStringEnv(ctx, logger, "NOMAD_OPS_PERSIST_REPOS", "FALSE") == "TRUE",
+				KnownHostsFile: env.GetStringEnv(ctx, logger, "NOMAD_OPS_KNOWN_HOSTS_FILE", ""),
+				HostKeyPolicy:  github.HostKeyPolicy(env.GetStringEnv(ctx, logger, "NOMAD_OPS_HOST_KEY_POLICY", string(github.HostKeyPolicyStrict))),
 			},
 			nomadAPI,
-			keyStore)
+			keyStore,
+			credentialStore,
+			knownHostStore)
 		if err != nil {
 			logger.LogError(ctx, "Could not CreateGitProvider:%v", err)
 			os.Exit(-2)
@@ -310,29 +367,72 @@ func main() {
 		watcher, err := application.CreateRepoWatcher(ctx,
 			log.NewSimpleLogger(trace, "RepoWatcher"),
 			application.RepoWatcherConfig{
-				Interval:        env.GetDurationEnv(ctx, logger, "NOMAD_OPS_POLLING_INTERVAL", 60*time.Second),
-				ErrorRetryCount: env.GetIntEnv(ctx, logger, "NOMAD_OPS_ERROR_RETRY_COUNT", 2),
-				AppName:         env.GetStringEnv(ctx, logger, "APP_NAME", "nomad-ops"),
+				Interval:         env.GetDurationEnv(ctx, logger, "NOMAD_OPS_POLLING_INTERVAL", 60*time.Second),
+				FallbackInterval: env.GetDurationEnv(ctx, logger, "NOMAD_OPS_FALLBACK_POLLING_INTERVAL", 15*time.Second),
+				ErrorRetryCount:  env.GetIntEnv(ctx, logger, "NOMAD_OPS_ERROR_RETRY_COUNT", 2),
+				AppName:          env.GetStringEnv(ctx, logger, "APP_NAME", "nomad-ops"),
+
+				SkipUnchangedSyncRuns: env.GetStringEnv(ctx, logger, "NOMAD_OPS_SYNC_RUN_SKIP_UNCHANGED", "TRUE") == "TRUE",
 			},
 			srcStore,
 			dsw,
 			notificationComposer,
-			vaultTokenStore)
+			vaultTokenStore,
+			teamStore,
+			syncRunStore)
 		if err != nil {
 			logger.LogError(ctx, "Could not CreateRepoWatcher:%v", err)
 			os.Exit(-2)
 		}
 
-		err = nomadAPI.SubscribeJobChanges(ctx, func(jobName string) {
-			err := watcher.SyncSourceByID(ctx, jobName, application.SyncSourceOptions{})
+		// deletes the sync history older than the retention
+		syncRunRetention := env.GetDurationEnv(ctx, logger, "NOMAD_OPS_SYNC_RUN_RETENTION", 7*24*time.Hour)
+		go func() {
+			ticker := time.NewTicker(time.Hour)
+			defer ticker.Stop()
+			for {
+				deleted, err := syncRunStore.DeleteSyncRunsBefore(ctx, time.Now().Add(-syncRunRetention))
+				if err != nil {
+					logger.LogError(ctx, "Could not DeleteSyncRunsBefore:%v", err)
+				} else if deleted > 0 {
+					logger.LogInfo(ctx, "Deleted %d sync runs older than %v", deleted, syncRunRetention)
+				}
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		hookReceiver, err := githooks.CreateReceiver(ctx,
+			log.NewSimpleLogger(trace, "GitHooks"),
+			githooks.ReceiverConfig{
+				Secret: strings.TrimSpace(ReadFromFile(ctx, logger, "NOMAD_OPS_WEBHOOK_SECRET_FILE", "")),
+			},
+			watcher,
+			webhookSecretStore)
+		if err != nil {
+			logger.LogError(ctx, "Could not CreateReceiver:%v", err)
+			os.Exit(-2)
+		}
+
+		eventDebounce := env.GetDurationEnv(ctx, logger, "NOMAD_OPS_EVENT_DEBOUNCE", time.Second*5)
+		eventDebouncer := debounce.New(eventDebounce, eventDebounce*6, func(srcID string) {
+			err := watcher.SyncSourceByID(ctx, srcID, application.SyncSourceOptions{
+				Trigger: domain.SyncTriggerNomadEvent,
+			})
 			if err == errors.ErrNotFound {
-				// not handled by us --- ignore
+				// source is not watched (anymore) --- ignore
 				return
 			}
 			if err != nil {
-				logger.LogError(ctx, "Could not UpdateSourceByID on Nomad Event:%v", err)
+				logger.LogError(ctx, "Could not SyncSourceByID on Nomad Event:%v", err)
 			}
 		})
+		err = nomadAPI.SubscribeJobChanges(ctx, eventDebouncer.Trigger, func(connected bool) {
+			watcher.SetEventStreamConnected(ctx, connected)
+		})
 		if err != nil {
 			logger.LogError(ctx, "Could not SubscribeJobChanges:%v", err)
 			os.Exit(-2)
@@ -340,17 +440,31 @@ func main() {
 
 		manager, err := application.CreateReconciliationManager(ctx,
 			log.NewSimpleLogger(trace, "ReconciliationManager"),
-			application.ReconciliationManagerConfig{},
+			application.ReconciliationManagerConfig{
+				MaxPrunePercent:   env.GetIntEnv(ctx, logger, "NOMAD_OPS_MAX_PRUNE_PERCENT", 0),
+				DeploymentTimeout: env.GetDurationEnv(ctx, logger, "NOMAD_OPS_DEPLOYMENT_TIMEOUT", 10*time.Minute),
+			},
 			srcStore,
 			watcher,
 			nomadAPI,
 			evStore,
-			notificationComposer)
+			notificationComposer,
+			srcStore)
 		if err != nil {
 			logger.LogError(ctx, "Could not CreateReconciliationManager:%v", err)
 			os.Exit(-2)
 		}
 
+		srcs, err := manager.ListSources(ctx, application.ListSourcesOptions{})
+		if err != nil {
+			logger.LogError(ctx, "Could not ListSources:%v", err)
+			os.Exit(-2)
+		}
+		err = dsw.PruneRepos(ctx, srcs)
+		if err != nil {
+			logger.LogError(ctx, "Could not PruneRepos:%v", err)
+		}
+
 		app.OnRecordAfterCreateRequest().Add(func(e *core.RecordCreateEvent) error {
 			if e.Collection.Name == "sources" {
 				logger.LogInfo(ctx, "Adding new source to watch...")
@@ -385,6 +499,19 @@ func main() {
 			return nil
 		})
 
+		app.OnRecordBeforeDeleteRequest().Add(func(e *core.RecordDeleteEvent) error {
+			if e.Collection.Name == "sources" {
+				logger.LogInfo(ctx, "Cleaning up jobs of deleted source...")
+				err := manager.OnDeletingSource(e.HttpContext.Request().Context(), domain.SourceFromRecord(e.Record, false))
+				if err != nil {
+					logger.LogError(ctx, "Could not clean up deleted source:%v", err)
+					return err
+				}
+			}
+
+			return nil
+		})
+
 		app.OnRecordAfterDeleteRequest().Add(func(e *core.RecordDeleteEvent) error {
 			if e.Collection.Name == "sources" {
 				logger.LogInfo(ctx, "Removing source from watch...")
@@ -467,9 +594,60 @@ func main() {
 					})
 				}
 
+				opts := application.SyncSourceOptions{
+					ForceRestart: false,
+				}
+				if c.QueryParam("overrideWindow") == "true" {
+					// emergency sync outside of the sync windows
+					opts.OverrideSyncWindowBy = authUserName(c)
+				}
+
 				logger.LogInfo(c.Request().Context(), "Syncing source %s...", id)
+				err := watcher.SyncSourceByID(c.Request().Context(), id, opts)
+
+				if err == errors.ErrNotFound {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Source was not found"),
+					})
+				}
+
+				if err != nil {
+					logger.LogError(c.Request().Context(), "Could not UpdateSourceByID:%v", err)
+					return c.JSON(http.StatusInternalServerError, domain.Error{
+						Message: log.ToStrPtr("Unexpected error"),
+					})
+				}
+
+				return c.JSON(http.StatusOK, map[string]string{}) // empty 200 OK response
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				requireSourceMember(app),
+				apis.RequireAdminOrRecordAuth("users"),
+				apis.ActivityLogger(e.App),
+				middleware.CORSWithConfig(middleware.CORSConfig{}),
+				middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
+		// add new "POST /api/actions/sources/prune" route
+		// syncs the source and prunes the given jobs if they are pending confirmation
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/api/actions/sources/prune",
+			Handler: func(c echo.Context) error {
+				id := c.QueryParam("id")
+				jobs := c.QueryParams()["job"]
+				if id == "" || len(jobs) == 0 {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr("Expected a valid 'id' and at least one 'job' parameter"),
+					})
+				}
+
+				logger.LogInfo(c.Request().Context(), "Confirming prune of jobs %v of source %s...", jobs, id)
 				err := watcher.SyncSourceByID(c.Request().Context(), id, application.SyncSourceOptions{
-					ForceRestart: false,
+					ConfirmPrune: jobs,
 				})
 
 				if err == errors.ErrNotFound {
@@ -479,7 +657,7 @@ func main() {
 				}
 
 				if err != nil {
-					logger.LogError(c.Request().Context(), "Could not UpdateSourceByID:%v", err)
+					logger.LogError(c.Request().Context(), "Could not SyncSourceByID:%v", err)
 					return c.JSON(http.StatusInternalServerError, domain.Error{
 						Message: log.ToStrPtr("Unexpected error"),
 					})
@@ -488,59 +666,7 @@ func main() {
 				return c.JSON(http.StatusOK, map[string]string{}) // empty 200 OK response
 			},
 			Middlewares: []echo.MiddlewareFunc{
-				func(next echo.HandlerFunc) echo.HandlerFunc {
-					return func(c echo.Context) error {
-						authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
-						if authRecord == nil {
-							return apis.NewForbiddenError("Only auth records can access this endpoint", nil)
-						}
-						id := c.QueryParam("id")
-						if id == "" {
-							return c.JSON(http.StatusBadRequest, domain.Error{
-								Message: log.ToStrPtr("Expected a valid 'id' parameter"),
-							})
-						}
-
-						rec, err := app.Dao().FindRecordById("sources", id)
-						if err != nil {
-							return err
-						}
-
-						teamIDs := rec.GetStringSlice("teams")
-
-						if len(teamIDs) == 0 {
-							// No team "owns" this source
-							// anybody may sync
-							return next(c)
-						}
-
-						found := false
-						for _, teamID := range teamIDs {
-							teamRec, err := app.Dao().FindRecordById("teams", teamID)
-							if err != nil {
-								return err
-							}
-
-							members := teamRec.GetStringSlice("members")
-							for _, member := range members {
-								if member == authRecord.Id {
-									found = true
-									break
-								}
-							}
-							if found {
-								break
-							}
-						}
-
-						if !found {
-							// This source is owned by at least one team and the authenticated user is NOT part of that
-							return apis.NewForbiddenError("Only team members can trigger a sync", nil)
-						}
-						// User is part of a team that owns this source
-						return next(c)
-					}
-				},
+				requireSourceMember(app),
 				apis.RequireAdminOrRecordAuth("users"),
 				apis.ActivityLogger(e.App),
 				middleware.CORSWithConfig(middleware.CORSConfig{}),
@@ -550,6 +676,443 @@ func main() {
 			},
 		})
 
+		// add new "POST /api/actions/sources/approve" route
+		// approves the commit pending approval, or rejects it with reject=true
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/api/actions/sources/approve",
+			Handler: func(c echo.Context) error {
+				id := c.QueryParam("id")
+				if id == "" {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr("Expected a valid 'id' parameter"),
+					})
+				}
+
+				rec, err := app.Dao().FindRecordById("sources", id)
+				if err != nil {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Source was not found"),
+					})
+				}
+				src := domain.SourceFromRecord(rec, true)
+				if src.Status == nil || src.Status.PendingApprovalCommit == "" {
+					return c.JSON(http.StatusConflict, domain.Error{
+						Message: log.ToStrPtr("No commit is waiting for approval"),
+					})
+				}
+				commit := c.QueryParam("commit")
+				if commit == "" {
+					commit = src.Status.PendingApprovalCommit
+				}
+				if commit != src.Status.PendingApprovalCommit {
+					return c.JSON(http.StatusConflict, domain.Error{
+						Message: log.ToStrPtr(fmt.Sprintf("Commit %s is not waiting for approval", commit)),
+					})
+				}
+
+				opts := application.SyncSourceOptions{
+					ReviewedBy: authUserName(c),
+				}
+				if c.QueryParam("reject") == "true" {
+					logger.LogInfo(c.Request().Context(), "Rejecting commit %s of source %s...", commit, id)
+					opts.RejectCommit = commit
+				} else {
+					logger.LogInfo(c.Request().Context(), "Approving commit %s of source %s...", commit, id)
+					opts.ApproveCommit = commit
+				}
+				err = watcher.SyncSourceByID(c.Request().Context(), id, opts)
+
+				if err == errors.ErrNotFound {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Source was not found"),
+					})
+				}
+
+				if err != nil {
+					logger.LogError(c.Request().Context(), "Could not SyncSourceByID:%v", err)
+					return c.JSON(http.StatusInternalServerError, domain.Error{
+						Message: log.ToStrPtr("Unexpected error"),
+					})
+				}
+
+				return c.JSON(http.StatusOK, map[string]string{}) // empty 200 OK response
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				requireSourceMember(app),
+				apis.RequireAdminOrRecordAuth("users"),
+				apis.ActivityLogger(e.App),
+				middleware.CORSWithConfig(middleware.CORSConfig{}),
+				middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
+		// add new "POST /api/actions/sources/pin" route
+		// syncs the source to an earlier commit and keeps it there until it is unpinned
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/api/actions/sources/pin",
+			Handler: func(c echo.Context) error {
+				id := c.QueryParam("id")
+				if id == "" {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr("Expected a valid 'id' parameter"),
+					})
+				}
+				commit := c.QueryParam("commit")
+				if !application.IsCommit(commit) {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr("Expected a full commit sha as 'commit' parameter"),
+					})
+				}
+
+				opts := application.SyncSourceOptions{
+					PinCommit: commit,
+					PinnedBy:  authUserName(c),
+				}
+				if c.QueryParam("overrideWindow") == "true" {
+					opts.OverrideSyncWindowBy = opts.PinnedBy
+				}
+
+				logger.LogInfo(c.Request().Context(), "Pinning source %s to commit %s...", id, commit)
+				err := watcher.SyncSourceByID(c.Request().Context(), id, opts)
+
+				if err == errors.ErrNotFound {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Source was not found"),
+					})
+				}
+
+				if err != nil {
+					logger.LogError(c.Request().Context(), "Could not SyncSourceByID:%v", err)
+					return c.JSON(http.StatusInternalServerError, domain.Error{
+						Message: log.ToStrPtr("Unexpected error"),
+					})
+				}
+
+				return c.JSON(http.StatusOK, map[string]string{}) // empty 200 OK response
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				requireSourceMember(app),
+				apis.RequireAdminOrRecordAuth("users"),
+				apis.ActivityLogger(e.App),
+				middleware.CORSWithConfig(middleware.CORSConfig{}),
+				middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
+		// add new "POST /api/actions/sources/unpin" route
+		// syncs the source to its revision again
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/api/actions/sources/unpin",
+			Handler: func(c echo.Context) error {
+				id := c.QueryParam("id")
+				if id == "" {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr("Expected a valid 'id' parameter"),
+					})
+				}
+
+				opts := application.SyncSourceOptions{
+					Unpin:    true,
+					PinnedBy: authUserName(c),
+				}
+				if c.QueryParam("overrideWindow") == "true" {
+					opts.OverrideSyncWindowBy = opts.PinnedBy
+				}
+
+				logger.LogInfo(c.Request().Context(), "Unpinning source %s...", id)
+				err := watcher.SyncSourceByID(c.Request().Context(), id, opts)
+
+				if err == errors.ErrNotFound {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Source was not found"),
+					})
+				}
+
+				if err != nil {
+					logger.LogError(c.Request().Context(), "Could not SyncSourceByID:%v", err)
+					return c.JSON(http.StatusInternalServerError, domain.Error{
+						Message: log.ToStrPtr("Unexpected error"),
+					})
+				}
+
+				return c.JSON(http.StatusOK, map[string]string{}) // empty 200 OK response
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				requireSourceMember(app),
+				apis.RequireAdminOrRecordAuth("users"),
+				apis.ActivityLogger(e.App),
+				middleware.CORSWithConfig(middleware.CORSConfig{}),
+				middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
+		// add new "POST /api/actions/sources/plan" route
+		// returns the changes a sync of the source at the given ref would apply, without applying them
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/api/actions/sources/plan",
+			Handler: func(c echo.Context) error {
+				id := c.QueryParam("id")
+				if id == "" {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr("Expected a valid 'id' parameter"),
+					})
+				}
+
+				rec, err := app.Dao().FindRecordById("sources", id)
+				if err != nil {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Source was not found"),
+					})
+				}
+
+				ref := c.QueryParam("ref")
+				logger.LogInfo(c.Request().Context(), "Planning source %s at %s...", id, ref)
+				plan, err := watcher.PlanSource(c.Request().Context(), domain.SourceFromRecord(rec, false), ref)
+
+				if err == errors.ErrNotFound {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Source is not watched"),
+					})
+				}
+
+				if err != nil {
+					logger.LogError(c.Request().Context(), "Could not PlanSource:%v", err)
+					return c.JSON(http.StatusInternalServerError, domain.Error{
+						Message: log.ToStrPtr(fmt.Sprintf("Could not plan source: %v", err)),
+					})
+				}
+
+				return c.JSON(http.StatusOK, plan)
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				requireSourceMember(app),
+				apis.RequireAdminOrRecordAuth("users"),
+				apis.ActivityLogger(e.App),
+				middleware.CORSWithConfig(middleware.CORSConfig{}),
+				middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
+		// add new "GET /api/actions/sources/adopt" route
+		// returns the diff that adopting an unmanaged job would apply
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/actions/sources/adopt",
+			Handler: func(c echo.Context) error {
+				id := c.QueryParam("id")
+				job := c.QueryParam("job")
+				if id == "" || job == "" {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr("Expected a valid 'id' and 'job' parameter"),
+					})
+				}
+
+				rec, err := app.Dao().FindRecordById("sources", id)
+				if err != nil {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Source was not found"),
+					})
+				}
+				src := domain.SourceFromRecord(rec, true)
+				if src.Status == nil || !src.Status.Jobs[job].Unmanaged {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Job is not waiting for adoption"),
+					})
+				}
+
+				return c.JSON(http.StatusOK, &application.UnmanagedJob{
+					Name:      job,
+					Namespace: src.Status.Jobs[job].Namespace,
+					SourceID:  src.ID,
+					Diff:      src.Status.Jobs[job].Diff,
+				})
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				requireSourceMember(app),
+				apis.RequireAdminOrRecordAuth("users"),
+				middleware.CORSWithConfig(middleware.CORSConfig{}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
+		// add new "POST /api/actions/sources/adopt" route
+		// claims an existing job for the source
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/api/actions/sources/adopt",
+			Handler: func(c echo.Context) error {
+				id := c.QueryParam("id")
+				job := c.QueryParam("job")
+				if id == "" || job == "" {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr("Expected a valid 'id' and 'job' parameter"),
+					})
+				}
+
+				logger.LogInfo(c.Request().Context(), "Adopting job %s for source %s...", job, id)
+				err := watcher.SyncSourceByID(c.Request().Context(), id, application.SyncSourceOptions{
+					Adopt: []string{job},
+				})
+
+				if err == errors.ErrNotFound {
+					return c.JSON(http.StatusNotFound, domain.Error{
+						Message: log.ToStrPtr("Source was not found"),
+					})
+				}
+
+				if err != nil {
+					logger.LogError(c.Request().Context(), "Could not SyncSourceByID:%v", err)
+					return c.JSON(http.StatusInternalServerError, domain.Error{
+						Message: log.ToStrPtr("Unexpected error"),
+					})
+				}
+
+				return c.JSON(http.StatusOK, map[string]string{}) // empty 200 OK response
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				requireSourceMember(app),
+				apis.RequireAdminOrRecordAuth("users"),
+				apis.ActivityLogger(e.App),
+				middleware.CORSWithConfig(middleware.CORSConfig{}),
+				middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
+		// add new "GET /api/reports/jobs" route
+		// lists orphaned jobs and jobs that have to be adopted
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/reports/jobs",
+			Handler: func(c echo.Context) error {
+				report, err := manager.GetJobReport(c.Request().Context())
+				if err != nil {
+					logger.LogError(c.Request().Context(), "Could not GetJobReport:%v", err)
+					return c.JSON(http.StatusInternalServerError, domain.Error{
+						Message: log.ToStrPtr("Unexpected error"),
+					})
+				}
+				return c.JSONPretty(http.StatusOK, report, "    ")
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.RequireAdminOrRecordAuth("users"),
+				middleware.CORSWithConfig(middleware.CORSConfig{}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
+		// add new "GET /api/reports/sync-runs" route
+		// lists the sync history, newest first
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/reports/sync-runs",
+			Handler: func(c echo.Context) error {
+				opts := application.ListSyncRunsOptions{
+					SourceID: c.QueryParam("source"),
+					Limit:    100,
+				}
+				for param, t := range map[string]*time.Time{
+					"since": &opts.Since,
+					"until": &opts.Until,
+				} {
+					v := c.QueryParam(param)
+					if v == "" {
+						continue
+					}
+					parsed, err := time.Parse(time.RFC3339, v)
+					if err != nil {
+						return c.JSON(http.StatusBadRequest, domain.Error{
+							Message: log.ToStrPtr(fmt.Sprintf("Expected '%s' to be a RFC3339 timestamp", param)),
+						})
+					}
+					*t = parsed
+				}
+				if v := c.QueryParam("limit"); v != "" {
+					limit, err := strconv.Atoi(v)
+					if err != nil || limit < 1 || limit > 1000 {
+						return c.JSON(http.StatusBadRequest, domain.Error{
+							Message: log.ToStrPtr("Expected 'limit' to be between 1 and 1000"),
+						})
+					}
+					opts.Limit = limit
+				}
+
+				runs, err := syncRunStore.ListSyncRuns(c.Request().Context(), opts)
+				if err != nil {
+					logger.LogError(c.Request().Context(), "Could not ListSyncRuns:%v", err)
+					return c.JSON(http.StatusInternalServerError, domain.Error{
+						Message: log.ToStrPtr("Unexpected error"),
+					})
+				}
+				return c.JSONPretty(http.StatusOK, runs, "    ")
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				apis.RequireAdminOrRecordAuth("users"),
+				middleware.CORSWithConfig(middleware.CORSConfig{}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
+		// add new "POST /api/hooks/:provider" route
+		// authenticated by the signature of the provider instead of a user
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodPost,
+			Path:   "/api/hooks/:provider",
+			Handler: func(c echo.Context) error {
+				body, err := io.ReadAll(c.Request().Body)
+				if err != nil {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr("Could not read body"),
+					})
+				}
+
+				ids, err := hookReceiver.HandlePush(c.Request().Context(), c.PathParam("provider"), c.Request().Header, body)
+				if goerrors.Is(err, errors.ErrInvalid) {
+					return c.JSON(http.StatusBadRequest, domain.Error{
+						Message: log.ToStrPtr(err.Error()),
+					})
+				}
+				if err == errors.ErrUnauthorized {
+					return c.JSON(http.StatusUnauthorized, domain.Error{
+						Message: log.ToStrPtr("Signature could not be verified"),
+					})
+				}
+				if err != nil {
+					logger.LogError(c.Request().Context(), "Could not HandlePush:%v", err)
+					return c.JSON(http.StatusInternalServerError, domain.Error{
+						Message: log.ToStrPtr("Unexpected error"),
+					})
+				}
+
+				return c.JSON(http.StatusAccepted, map[string][]string{
+					"sources": ids,
+				})
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{
+					LimitBytes: 5 * 1024 * 1024,
+				}),
+				middleware.Recover(),
+				middleware.LoggerWithConfig(middleware.LoggerConfig{}),
+			},
+		})
+
 		e.Router.AddRoute(echo.Route{
 			Method: http.MethodGet, // Read only, but still a user might see too much
 			Path:   "/api/nomad/proxy/*",
@@ -617,6 +1180,24 @@ func main() {
 			},
 		})
 
+		e.Router.AddRoute(echo.Route{
+			Method: http.MethodGet,
+			Path:   "/api/health/nomad",
+			Handler: func(c echo.Context) error {
+				if !watcher.IsEventStreamConnected() {
+					return c.JSONPretty(http.StatusServiceUnavailable, map[string]string{
+						"eventStream": "disconnected",
+					}, "    ")
+				}
+				return c.JSONPretty(http.StatusOK, map[string]string{
+					"eventStream": "connected",
+				}, "    ")
+			},
+			Middlewares: []echo.MiddlewareFunc{
+				middleware.Recover(),
+			},
+		})
+
 		logger.LogInfo(ctx, "Initialization done")
 
 		_, err = mon.StartMon(ctx, log.NewSimpleLogger(logger.IsTraceEnabled(ctx), "Monitor"), mon.Config{
@@ -645,3 +1226,72 @@ func ReadFromFile(ctx context.Context, logger log.Logger, key string, def string
 	}
 	return string(b)
 }
+
+// authUserName returns the email of the authenticated user, or the username if it has none
+func authUserName(c echo.Context) string {
+	authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+	if authRecord == nil {
+		return ""
+	}
+	if authRecord.Email() != "" {
+		return authRecord.Email()
+	}
+	return authRecord.Username()
+}
+
+// requireSourceMember allows requests with an "id" parameter only for members of a team that owns the source
+func requireSourceMember(app *pocketbase.PocketBase) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authRecord, _ := c.Get(apis.ContextAuthRecordKey).(*models.Record)
+			if authRecord == nil {
+				return apis.NewForbiddenError("Only auth records can access this endpoint", nil)
+			}
+			id := c.QueryParam("id")
+			if id == "" {
+				return c.JSON(http.StatusBadRequest, domain.Error{
+					Message: log.ToStrPtr("Expected a valid 'id' parameter"),
+				})
+			}
+
+			rec, err := app.Dao().FindRecordById("sources", id)
+			if err != nil {
+				return err
+			}
+
+			teamIDs := rec.GetStringSlice("teams")
+
+			if len(teamIDs) == 0 {
+				// No team "owns" this source
+				// anybody may sync
+				return next(c)
+			}
+
+			found := false
+			for _, teamID := range teamIDs {
+				teamRec, err := app.Dao().FindRecordById("teams", teamID)
+				if err != nil {
+					return err
+				}
+
+				members := teamRec.GetStringSlice("members")
+				for _, member := range members {
+					if member == authRecord.Id {
+						found = true
+						break
+					}
+				}
+				if found {
+					break
+				}
+			}
+
+			if !found {
+				// This source is owned by at least one team and the authenticated user is NOT part of that
+				return apis.NewForbiddenError("Only team members can trigger a sync", nil)
+			}
+			// User is part of a team that owns this source
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+type CredentialType string
+
+const (
+	// CredentialTypeBasic uses username and password for http basic auth
+	CredentialTypeBasic CredentialType = "basic"
+	// CredentialTypeToken uses a personal access token as the password of http basic auth
+	CredentialTypeToken CredentialType = "token"
+	// CredentialTypeGitHubApp requests installation tokens for a GitHub App
+	CredentialTypeGitHubApp CredentialType = "githubApp"
+)
+
+// Credential to authenticate against https git remotes
+type Credential struct {
+
+	// id
+	// Read Only: true
+	ID string `json:"id,omitempty"`
+
+	// name
+	// Required: true
+	Name string `json:"name"`
+
+	// created
+	// Read Only: true
+	Created time.Time `json:"timestamp,omitempty"`
+
+	// type
+	// Required: true
+	Type CredentialType `json:"type"`
+
+	// username, optional for tokens
+	Username string `json:"username,omitempty"`
+
+	// password or token, unused for GitHub Apps
+	Password string `json:"password,omitempty"`
+
+	// GitHub App ID
+	AppID string `json:"appID,omitempty"`
+
+	// GitHub App installation ID
+	InstallationID string `json:"installationID,omitempty"`
+
+	// GitHub App private key (PEM)
+	PrivateKey string `json:"privateKey,omitempty"`
+
+	// GitHub API url, defaults to https://api.github.com
+	APIURL string `json:"apiURL,omitempty"`
+
+	// teamID of owner
+	TeamID string `json:"teamID,omitempty"`
+}
+
+func initCredentialCollection(app core.App,
+	teamsCollection *models.Collection) (*models.Collection, error) {
+
+	collection, err := app.Dao().FindCollectionByNameOrId("credentials")
+
+	if err == sql.ErrNoRows {
+		collection = &models.Collection{}
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	form := forms.NewCollectionUpsert(app, collection)
+	form.Name = "credentials"
+	form.Type = models.CollectionTypeBase
+	form.ListRule = types.Pointer("@request.auth.id != '' && (team = '' || team.members.id ?= @request.auth.id)")
+	form.ViewRule = types.Pointer("@request.auth.id != '' && (team = '' || team.members.id ?= @request.auth.id)")
+	form.CreateRule = types.Pointer("@request.auth.id != ''")
+	form.UpdateRule = types.Pointer("@request.auth.id != '' && (team = '' || team.members.id ?= @request.auth.id)")
+	form.DeleteRule = types.Pointer("@request.auth.id != '' && (team = '' || team.members.id ?= @request.auth.id)")
+	form.Indexes = types.JsonArray[string]{
+		"create unique index credential_unique on credentials (name)",
+	}
+
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "name",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(100),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "type",
+		Type:     schema.FieldTypeSelect,
+		Required: true,
+		Options: &schema.SelectOptions{
+			MaxSelect: 1,
+			Values: []string{
+				string(CredentialTypeBasic),
+				string(CredentialTypeToken),
+				string(CredentialTypeGitHubApp),
+			},
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "username",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(200),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "password",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(1000),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "appID",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(100),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "installationID",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(100),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "privateKey",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(5000),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "apiURL",
+		Type:     schema.FieldTypeUrl,
+		Required: false,
+		Options:  &schema.UrlOptions{},
+	})
+	max := 1
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "team",
+		Type:     schema.FieldTypeRelation,
+		Required: false, // optional, if not set every team can see this
+		Options: &schema.RelationOptions{
+			CollectionId: teamsCollection.Id,
+			MaxSelect:    &max,
+		},
+	})
+
+	// validate and submit (internally it calls app.Dao().SaveCollection(collection) in a transaction)
+	if err := form.Submit(); err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+func CredentialFromRecord(record *models.Record) *Credential {
+	return &Credential{
+		ID:             record.Id,
+		Name:           record.GetString("name"),
+		Created:        record.Created.Time(),
+		Type:           CredentialType(record.GetString("type")),
+		Username:       record.GetString("username"),
+		Password:       record.GetString("password"),
+		AppID:          record.GetString("appID"),
+		InstallationID: record.GetString("installationID"),
+		PrivateKey:     record.GetString("privateKey"),
+		APIURL:         record.GetString("apiURL"),
+		TeamID:         record.GetString("team"),
+	}
+}
@@ -17,12 +17,19 @@ import (
 type EventType string
 
 const (
-	EventTypeSynced  EventType = "synced"
-	EventTypeUpdated EventType = "updated"
-	EventTypeCreated EventType = "created"
-	EventTypeDeleted EventType = "deleted"
-	EventTypePaused  EventType = "paused"
-	EventTypeResumed EventType = "resumed"
+	EventTypeSynced           EventType = "synced"
+	EventTypeUpdated          EventType = "updated"
+	EventTypeCreated          EventType = "created"
+	EventTypeDeleted          EventType = "deleted"
+	EventTypePaused           EventType = "paused"
+	EventTypeResumed          EventType = "resumed"
+	EventTypeDrifted          EventType = "drifted"
+	EventTypeRolledBack       EventType = "rolledback"
+	EventTypeWindowOverridden EventType = "windowoverridden"
+	EventTypeApproved         EventType = "approved"
+	EventTypeRejected         EventType = "rejected"
+	EventTypePinned           EventType = "pinned"
+	EventTypeUnpinned         EventType = "unpinned"
 )
 
 type Event struct {
@@ -84,12 +91,19 @@ func initEventCollection(app core.App,
 		Options: &schema.SelectOptions{
 			MaxSelect: 1,
 			Values: []string{
+				string(EventTypeApproved),
 				string(EventTypeCreated),
 				string(EventTypeDeleted),
+				string(EventTypeDrifted),
 				string(EventTypePaused),
+				string(EventTypePinned),
+				string(EventTypeRejected),
 				string(EventTypeResumed),
+				string(EventTypeRolledBack),
 				string(EventTypeSynced),
+				string(EventTypeUnpinned),
 				string(EventTypeUpdated),
+				string(EventTypeWindowOverridden),
 			},
 		},
 	})
@@ -37,13 +37,30 @@ func InitModels(ctx context.Context, logger log.Logger, app core.App) error {
 		logger.LogError(ctx, "Could not initKeyCollection:%v - %T", err, err)
 		return err
 	}
+	_, err = initKnownHostCollection(app)
+	if err != nil {
+		logger.LogError(ctx, "Could not initKnownHostCollection:%v - %T", err, err)
+		return err
+	}
+	credentialCollection, err := initCredentialCollection(app, teamCollection)
+	if err != nil {
+		logger.LogError(ctx, "Could not initCredentialCollection:%v - %T", err, err)
+		return err
+	}
 	vaultTokenCollection, err := initVaultTokenCollection(app, teamCollection)
 	if err != nil {
 		logger.LogError(ctx, "Could not initVaultTokenCollection:%v - %T", err, err)
 		return err
 	}
 
-	srcCollection, err := initSourceCollection(app, keyCollection, teamCollection, vaultTokenCollection)
+	webhookSecretCollection, err := initWebhookSecretCollection(app, teamCollection)
+	if err != nil {
+		logger.LogError(ctx, "Could not initWebhookSecretCollection:%v - %T", err, err)
+		return err
+	}
+
+	srcCollection, err := initSourceCollection(app, keyCollection, credentialCollection, teamCollection, vaultTokenCollection,
+		webhookSecretCollection)
 	if err != nil {
 		logger.LogError(ctx, "Could not initSourceCollection:%v - %T", err, err)
 		return err
@@ -54,6 +71,11 @@ func InitModels(ctx context.Context, logger log.Logger, app core.App) error {
 		logger.LogError(ctx, "Could not initEventCollection:%v", err)
 		return err
 	}
+	_, err = initSyncRunCollection(app, srcCollection)
+	if err != nil {
+		logger.LogError(ctx, "Could not initSyncRunCollection:%v", err)
+		return err
+	}
 	return nil
 }
 
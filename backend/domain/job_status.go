@@ -25,4 +25,13 @@ type JobStatus struct {
 
 	// diff
 	Diff json.RawMessage `json:"diff,omitempty"`
+
+	// task groups nomad could not place when planning the job
+	PlacementFailures json.RawMessage `json:"placementFailures,omitempty"`
+
+	// true if the job was changed in nomad and differs from git, Diff contains the changes git would apply
+	Drifted bool `json:"drifted,omitempty"`
+
+	// true if the job exists in nomad, but is not managed by this source and has to be adopted
+	Unmanaged bool `json:"unmanaged,omitempty"`
 }
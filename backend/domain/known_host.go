@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// KnownHost is a trusted ssh host key of a git server
+type KnownHost struct {
+
+	// id
+	// Read Only: true
+	ID string `json:"id,omitempty"`
+
+	// host, e.g. github.com or [git.example.com]:2222
+	// Required: true
+	Host string `json:"host"`
+
+	// public key in authorized_keys format, e.g. ssh-ed25519 AAAA...
+	// Required: true
+	Key string `json:"key"`
+
+	// SHA256 fingerprint of the key
+	// Read Only: true
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// created
+	// Read Only: true
+	Created time.Time `json:"timestamp,omitempty"`
+}
+
+func initKnownHostCollection(app core.App) (*models.Collection, error) {
+
+	collection, err := app.Dao().FindCollectionByNameOrId("known_hosts")
+
+	if err == sql.ErrNoRows {
+		collection = &models.Collection{}
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	form := forms.NewCollectionUpsert(app, collection)
+	form.Name = "known_hosts"
+	form.Type = models.CollectionTypeBase
+	// trusted host keys apply to the repos of every team, only admins manage them
+	form.ListRule = nil
+	form.ViewRule = nil
+	form.CreateRule = nil
+	form.UpdateRule = nil
+	form.DeleteRule = nil
+	form.Indexes = types.JsonArray[string]{
+		"create unique index known_host_unique on known_hosts (host, key)",
+	}
+
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "host",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(200),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "key",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(2000),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "fingerprint",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(200),
+		},
+	})
+
+	// validate and submit (internally it calls app.Dao().SaveCollection(collection) in a transaction)
+	if err := form.Submit(); err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+func KnownHostFromRecord(record *models.Record) *KnownHost {
+	return &KnownHost{
+		ID:          record.Id,
+		Host:        record.GetString("host"),
+		Key:         record.GetString("key"),
+		Fingerprint: record.GetString("fingerprint"),
+		Created:     record.Created.Time(),
+	}
+}
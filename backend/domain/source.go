@@ -11,6 +11,59 @@ import (
 	"github.com/pocketbase/pocketbase/tools/types"
 )
 
+type RevisionKind string
+
+const (
+	// RevisionKindBranch follows the latest commit of a branch
+	RevisionKindBranch RevisionKind = "branch"
+	// RevisionKindTag deploys a fixed tag
+	RevisionKindTag RevisionKind = "tag"
+	// RevisionKindSemver follows the newest tag matching a semver constraint, e.g. "v1.4.*" or ">=1.2.0, <2.0.0"
+	RevisionKindSemver RevisionKind = "semver"
+	// RevisionKindCommit pins an exact commit
+	RevisionKindCommit RevisionKind = "commit"
+)
+
+type SelfHealMode string
+
+const (
+	// SelfHealEnabled overwrites changes made in nomad with the state in git
+	SelfHealEnabled SelfHealMode = "enabled"
+	// SelfHealDisabled reports changes made in nomad as drift, git only wins with a new commit
+	SelfHealDisabled SelfHealMode = "disabled"
+)
+
+type PruneMode string
+
+const (
+	// PruneAuto deletes jobs that were removed from git
+	PruneAuto PruneMode = "auto"
+	// PruneConfirm deletes jobs that were removed from git only after a confirmation
+	PruneConfirm PruneMode = "confirm"
+	// PruneNever keeps jobs that were removed from git
+	PruneNever PruneMode = "never"
+)
+
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyOrphan keeps the jobs of a deleted source
+	DeletionPolicyOrphan DeletionPolicy = "orphan"
+	// DeletionPolicyDeregister stops the jobs of a deleted source
+	DeletionPolicyDeregister DeletionPolicy = "deregister"
+	// DeletionPolicyPurge stops and purges the jobs of a deleted source
+	DeletionPolicyPurge DeletionPolicy = "purge"
+)
+
+type SourceType string
+
+const (
+	// SourceTypeJobs reads job files
+	SourceTypeJobs SourceType = "jobs"
+	// SourceTypePack renders the nomad pack at the path with VarFiles and Variables as values
+	SourceTypePack SourceType = "pack"
+)
+
 // Source A source to watch
 //
 // swagger:model Source
@@ -24,35 +77,96 @@ type Source struct {
 	// Required: true
 	Name string `json:"name"`
 
-	// branch
+	// branch, tag, semver constraint or commit depending on RevisionKind
 	// Required: true
 	Branch string `json:"branch"`
 
+	// how Branch is resolved, defaults to branch
+	RevisionKind RevisionKind `json:"revisionKind,omitempty"`
+
 	// if true the namespace will be created if it does not exist
 	CreateNamespace bool `json:"createNamespace,omitempty"`
 
 	// if set, will override whatever is written in the job file. Use comma to provide multiple.
 	DataCenter string `json:"dataCenter,omitempty"`
 
-	// deployKeyID to use
+	// deployKeyID to use for ssh urls
 	DeployKeyID string `json:"deployKeyID,omitempty"`
 
+	// credentialID to use for https urls
+	CredentialID string `json:"credentialID,omitempty"`
+
 	// vaultTokenID to use
 	VaultTokenID string `json:"vaultTokenID,omitempty"`
 
+	// webhookSecretID used to verify incoming git push webhooks. Falls back to the global secret if empty
+	WebhookSecretID string `json:"webhookSecretID,omitempty"`
+
 	// if true every commit forces an job update
 	Force bool `json:"force,omitempty"`
 
 	// if true no syncing is paused
 	Paused bool `json:"paused,omitempty"`
 
+	// whether changes made in nomad are overwritten, defaults to enabled
+	SelfHeal SelfHealMode `json:"selfHeal,omitempty"`
+
+	// what happens to jobs that were removed from git, defaults to auto
+	Prune PruneMode `json:"prune,omitempty"`
+
+	// if true a sync waits for the deployments of the changed jobs before notifying
+	WaitForDeployment bool `json:"waitForDeployment,omitempty"`
+
+	// if true new commits are only planned until they are approved
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
+	// if true a failed deployment reverts the job to its last stable version and
+	// the commit is not synced again
+	AutoRollback bool `json:"autoRollback,omitempty"`
+
+	// what happens to the jobs when the source is deleted, defaults to orphan
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// windows in which automatic syncs are allowed or denied, the windows of the teams apply as well
+	SyncWindows []SyncWindow `json:"syncWindows,omitempty"`
+
+	// ids of the teams owning the source
+	TeamIDs []string `json:"teams,omitempty"`
+
 	// if set, will override whatever is written in the job file
 	Namespace string `json:"namespace,omitempty"`
 
-	// path in the repo
+	// how jobs are read from Path, defaults to jobs
+	Type SourceType `json:"type,omitempty"`
+
+	// path in the repo, can be a file, a directory or a glob pattern like apps/**/*.nomad.hcl
 	// Required: true
 	Path string `json:"path"`
 
+	// if set, directories are searched recursively and only files matching one of these patterns are used
+	Include []string `json:"include,omitempty"`
+
+	// files matching one of these patterns are ignored
+	Exclude []string `json:"exclude,omitempty"`
+
+	// if true job files are rendered as go templates before they are parsed
+	Template bool `json:"template,omitempty"`
+
+	// delimiters of templates separated by a space, defaults to "[[ ]]"
+	TemplateDelims string `json:"templateDelims,omitempty"`
+
+	// paths of .json or .hcl files in the repo with the values of templates
+	ValuesFiles []string `json:"valuesFiles,omitempty"`
+
+	// values of templates, override values of ValuesFiles
+	Values map[string]interface{} `json:"values,omitempty"`
+
+	// HCL2 input variables passed to all jobs of the source, override values of VarFiles
+	Variables map[string]string `json:"variables,omitempty"`
+
+	// paths of HCL2 variable files in the repo, e.g. vars/prod.hcl
+	VarFiles []string `json:"varFiles,omitempty"`
+
 	// region
 	Region string `json:"region,omitempty"`
 
@@ -67,8 +181,10 @@ type Source struct {
 
 func initSourceCollection(app core.App,
 	keysCollection *models.Collection,
+	credentialsCollection *models.Collection,
 	teamsCollection *models.Collection,
-	vaultTokenCollection *models.Collection) (*models.Collection, error) {
+	vaultTokenCollection *models.Collection,
+	webhookSecretCollection *models.Collection) (*models.Collection, error) {
 
 	collection, err := app.Dao().FindCollectionByNameOrId("sources")
 
@@ -112,6 +228,20 @@ func initSourceCollection(app core.App,
 			Max: types.Pointer(100),
 		},
 	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "revisionKind",
+		Type:     schema.FieldTypeSelect,
+		Required: false,
+		Options: &schema.SelectOptions{
+			MaxSelect: 1,
+			Values: []string{
+				string(RevisionKindBranch),
+				string(RevisionKindTag),
+				string(RevisionKindSemver),
+				string(RevisionKindCommit),
+			},
+		},
+	})
 	addOrUpdateField(form, &schema.SchemaField{
 		Name:     "path",
 		Type:     schema.FieldTypeText,
@@ -154,6 +284,15 @@ func initSourceCollection(app core.App,
 			MaxSelect:    &max,
 		},
 	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "credential",
+		Type:     schema.FieldTypeRelation,
+		Required: false,
+		Options: &schema.RelationOptions{
+			CollectionId: credentialsCollection.Id,
+			MaxSelect:    &max,
+		},
+	})
 	addOrUpdateField(form, &schema.SchemaField{
 		Name:     "force",
 		Type:     schema.FieldTypeBool,
@@ -164,6 +303,59 @@ func initSourceCollection(app core.App,
 		Type:     schema.FieldTypeBool,
 		Required: false,
 	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "waitForDeployment",
+		Type:     schema.FieldTypeBool,
+		Required: false,
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "autoRollback",
+		Type:     schema.FieldTypeBool,
+		Required: false,
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "requireApproval",
+		Type:     schema.FieldTypeBool,
+		Required: false,
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "selfHeal",
+		Type:     schema.FieldTypeSelect,
+		Required: false,
+		Options: &schema.SelectOptions{
+			MaxSelect: 1,
+			Values: []string{
+				string(SelfHealEnabled),
+				string(SelfHealDisabled),
+			},
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "prune",
+		Type:     schema.FieldTypeSelect,
+		Required: false,
+		Options: &schema.SelectOptions{
+			MaxSelect: 1,
+			Values: []string{
+				string(PruneAuto),
+				string(PruneConfirm),
+				string(PruneNever),
+			},
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "deletionPolicy",
+		Type:     schema.FieldTypeSelect,
+		Required: false,
+		Options: &schema.SelectOptions{
+			MaxSelect: 1,
+			Values: []string{
+				string(DeletionPolicyOrphan),
+				string(DeletionPolicyDeregister),
+				string(DeletionPolicyPurge),
+			},
+		},
+	})
 	addOrUpdateField(form, &schema.SchemaField{
 		Name:     "status",
 		Type:     schema.FieldTypeJson,
@@ -190,6 +382,96 @@ func initSourceCollection(app core.App,
 			MaxSelect:    &max,
 		},
 	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "type",
+		Type:     schema.FieldTypeSelect,
+		Required: false,
+		Options: &schema.SelectOptions{
+			MaxSelect: 1,
+			Values: []string{
+				string(SourceTypeJobs),
+				string(SourceTypePack),
+			},
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "include",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Options: &schema.JsonOptions{
+			MaxSize: 65536,
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "exclude",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Options: &schema.JsonOptions{
+			MaxSize: 65536,
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "syncWindows",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Options: &schema.JsonOptions{
+			MaxSize: 65536,
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "template",
+		Type:     schema.FieldTypeBool,
+		Required: false,
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "templateDelims",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(20),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "valuesFiles",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Options: &schema.JsonOptions{
+			MaxSize: 65536,
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "values",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Options: &schema.JsonOptions{
+			MaxSize: 1048576,
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "variables",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Options: &schema.JsonOptions{
+			MaxSize: 65536,
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "varFiles",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Options: &schema.JsonOptions{
+			MaxSize: 65536,
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "webhookSecret",
+		Type:     schema.FieldTypeRelation,
+		Required: false,
+		Options: &schema.RelationOptions{
+			CollectionId: webhookSecretCollection.Id,
+			MaxSelect:    &max,
+		},
+	})
 
 	// validate and submit (internally it calls app.Dao().SaveCollection(collection) in a transaction)
 	if err := form.Submit(); err != nil {
@@ -211,21 +493,84 @@ func SourceFromRecord(record *models.Record, withStatus bool) *Source {
 		status = nil
 	}
 	src := &Source{
-		ID:              record.Id,
-		Name:            record.GetString("name"),
-		URL:             record.GetString("url"),
-		Branch:          record.GetString("branch"),
-		Path:            record.GetString("path"),
-		DataCenter:      record.GetString("dataCenter"),
-		Region:          record.GetString("region"),
-		Namespace:       record.GetString("namespace"),
-		DeployKeyID:     record.GetString("deployKey"),
-		VaultTokenID:    record.GetString("vaultToken"),
-		CreateNamespace: record.GetBool("createNamespace"),
-		Force:           record.GetBool("force"),
-		Paused:          record.GetBool("paused"),
-		Status:          status,
+		ID:                record.Id,
+		Name:              record.GetString("name"),
+		URL:               record.GetString("url"),
+		Branch:            record.GetString("branch"),
+		RevisionKind:      RevisionKind(record.GetString("revisionKind")),
+		Path:              record.GetString("path"),
+		Type:              SourceType(record.GetString("type")),
+		Template:          record.GetBool("template"),
+		TemplateDelims:    record.GetString("templateDelims"),
+		DataCenter:        record.GetString("dataCenter"),
+		Region:            record.GetString("region"),
+		Namespace:         record.GetString("namespace"),
+		DeployKeyID:       record.GetString("deployKey"),
+		CredentialID:      record.GetString("credential"),
+		VaultTokenID:      record.GetString("vaultToken"),
+		WebhookSecretID:   record.GetString("webhookSecret"),
+		CreateNamespace:   record.GetBool("createNamespace"),
+		Force:             record.GetBool("force"),
+		Paused:            record.GetBool("paused"),
+		WaitForDeployment: record.GetBool("waitForDeployment"),
+		AutoRollback:      record.GetBool("autoRollback"),
+		RequireApproval:   record.GetBool("requireApproval"),
+		SelfHeal:          SelfHealMode(record.GetString("selfHeal")),
+		Prune:             PruneMode(record.GetString("prune")),
+		DeletionPolicy:    DeletionPolicy(record.GetString("deletionPolicy")),
+		TeamIDs:           record.GetStringSlice("teams"),
+		Status:            status,
+	}
+
+	err := record.UnmarshalJSONField("include", &src.Include)
+	if err != nil {
+		fmt.Printf("Could not unmarshal include field:%v", err)
+	}
+	err = record.UnmarshalJSONField("exclude", &src.Exclude)
+	if err != nil {
+		fmt.Printf("Could not unmarshal exclude field:%v", err)
+	}
+	err = record.UnmarshalJSONField("valuesFiles", &src.ValuesFiles)
+	if err != nil {
+		fmt.Printf("Could not unmarshal valuesFiles field:%v", err)
+	}
+	err = record.UnmarshalJSONField("values", &src.Values)
+	if err != nil {
+		fmt.Printf("Could not unmarshal values field:%v", err)
+	}
+	err = record.UnmarshalJSONField("syncWindows", &src.SyncWindows)
+	if err != nil {
+		fmt.Printf("Could not unmarshal syncWindows field:%v", err)
+	}
+	err = record.UnmarshalJSONField("variables", &src.Variables)
+	if err != nil {
+		fmt.Printf("Could not unmarshal variables field:%v", err)
+	}
+	err = record.UnmarshalJSONField("varFiles", &src.VarFiles)
+	if err != nil {
+		fmt.Printf("Could not unmarshal varFiles field:%v", err)
+	}
+
+	if src.SelfHeal == "" {
+		src.SelfHeal = SelfHealEnabled
+	}
+	if src.Prune == "" {
+		src.Prune = PruneAuto
+	}
+	if src.DeletionPolicy == "" {
+		src.DeletionPolicy = DeletionPolicyOrphan
+	}
+	if src.Type == "" {
+		src.Type = SourceTypeJobs
+	}
+	if src.RevisionKind == "" {
+		src.RevisionKind = RevisionKindBranch
 	}
 
 	return src
 }
+
+// SelfHealEnabled returns true unless self heal was disabled explicitly
+func (s *Source) SelfHealEnabled() bool {
+	return s.SelfHeal != SelfHealDisabled
+}
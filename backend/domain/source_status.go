@@ -22,12 +22,50 @@ type SourceStatus struct {
 	// Read Only: true
 	Message string `json:"message,omitempty"`
 
+	// commit that was rolled back after a failed deployment, it is not synced again
+	// Read Only: true
+	RolledBackCommit string `json:"rolledBackCommit,omitempty"`
+
+	// last commit that was approved, if the source requires approval
+	// Read Only: true
+	ApprovedCommit string `json:"approvedCommit,omitempty"`
+
+	// commit whose planned changes wait for an approval
+	// Read Only: true
+	PendingApprovalCommit string `json:"pendingApprovalCommit,omitempty"`
+
+	// commit that was rejected, it is not synced
+	// Read Only: true
+	RejectedCommit string `json:"rejectedCommit,omitempty"`
+
+	// commit the source is pinned to instead of its revision, until it is unpinned
+	// Read Only: true
+	PinnedCommit string `json:"pinnedCommit,omitempty"`
+
+	// user who pinned the source
+	// Read Only: true
+	PinnedBy string `json:"pinnedBy,omitempty"`
+
+	// jobs that are no longer in git, but were not pruned yet
+	// Read Only: true
+	PendingPrune []string `json:"pendingPrune,omitempty"`
+
+	// changes that were held back by a pause, a sync window or a pending approval
+	// Read Only: true
+	PendingChanges *PendingChanges `json:"pendingChanges,omitempty"`
+
 	// status
 	// Read Only: true
 	// Enum: [synced error unknown syncing init]
 	Status string `json:"status,omitempty"`
 }
 
+type PendingChanges struct {
+	Create []string `json:"create,omitempty"`
+	Update []string `json:"update,omitempty"`
+	Delete []string `json:"delete,omitempty"`
+}
+
 func (s *SourceStatus) DetermineSyncStatus() bool {
 	pending := false
 
@@ -39,7 +77,7 @@ func (s *SourceStatus) DetermineSyncStatus() bool {
 				statusMsg = fmt.Sprintf("Deployment pending for job: %s", key)
 			}
 		}
-		if job.DeploymentStatus == "failed" {
+		if job.DeploymentStatus == "failed" && s.Status != SourceStatusStatusRolledBack {
 			s.Status = SourceStatusStatusSyncedWithError
 			statusMsg = fmt.Sprintf("Deployment failed for job: %s", key)
 		}
@@ -58,11 +96,21 @@ const (
 
 	SourceStatusStatusSyncedWithError string = "syncedwitherror"
 
+	SourceStatusStatusDrifted string = "drifted"
+
+	SourceStatusStatusRolledBack string = "rolledback"
+
+	SourceStatusStatusBlockedByWindow string = "blocked-by-window"
+
+	SourceStatusStatusPendingApproval string = "pending-approval"
+
 	SourceStatusStatusError string = "error"
 
 	SourceStatusStatusUnknown string = "unknown"
 
 	SourceStatusStatusSyncing string = "syncing"
 
+	SourceStatusStatusProgressing string = "progressing"
+
 	SourceStatusStatusInit string = "init"
 )
@@ -0,0 +1,212 @@
+package domain
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+type SyncTrigger string
+
+const (
+	SyncTriggerPoll         SyncTrigger = "poll"
+	SyncTriggerWebhook      SyncTrigger = "webhook"
+	SyncTriggerManual       SyncTrigger = "manual"
+	SyncTriggerNomadEvent   SyncTrigger = "nomad-event"
+	SyncTriggerSourceUpdate SyncTrigger = "source-update"
+)
+
+type JobAction string
+
+const (
+	JobActionCreate    JobAction = "create"
+	JobActionUpdate    JobAction = "update"
+	JobActionDelete    JobAction = "delete"
+	JobActionDrifted   JobAction = "drifted"
+	JobActionUnmanaged JobAction = "unmanaged"
+)
+
+// SyncRun A single reconcile of a source
+type SyncRun struct {
+
+	// id
+	// Read Only: true
+	ID string `json:"id,omitempty"`
+
+	SourceID string `json:"source"`
+
+	StartedAt time.Time `json:"startedAt"`
+
+	FinishedAt time.Time `json:"finishedAt"`
+
+	GitCommit string `json:"gitCommit,omitempty"`
+
+	GitRef string `json:"gitRef,omitempty"`
+
+	Trigger SyncTrigger `json:"trigger"`
+
+	// true if the changes were only planned, e.g. because the source is paused
+	DryRun bool `json:"dryRun"`
+
+	// status of the source after the run
+	Result string `json:"result"`
+
+	Message string `json:"message,omitempty"`
+
+	Error string `json:"error,omitempty"`
+
+	Jobs []SyncRunJob `json:"jobs,omitempty"`
+}
+
+type SyncRunJob struct {
+	Name string `json:"name"`
+
+	Namespace string `json:"namespace,omitempty"`
+
+	Action JobAction `json:"action"`
+
+	Diff json.RawMessage `json:"diff,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+func initSyncRunCollection(app core.App,
+	srcCollection *models.Collection) (*models.Collection, error) {
+
+	collection, err := app.Dao().FindCollectionByNameOrId("sync_runs")
+
+	if err == sql.ErrNoRows {
+		collection = &models.Collection{}
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	form := forms.NewCollectionUpsert(app, collection)
+	form.Name = "sync_runs"
+	form.Type = models.CollectionTypeBase
+	// runs are only written by nomad-ops
+	form.ListRule = types.Pointer("@request.auth.id != ''")
+	form.ViewRule = types.Pointer("@request.auth.id != ''")
+	form.CreateRule = nil
+	form.UpdateRule = nil
+	form.DeleteRule = nil
+
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "source",
+		Type:     schema.FieldTypeRelation,
+		Required: true,
+		Options: &schema.RelationOptions{
+			MaxSelect:     types.Pointer(1),
+			CollectionId:  srcCollection.Id,
+			CascadeDelete: true,
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "startedAt",
+		Type:     schema.FieldTypeDate,
+		Required: true,
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "finishedAt",
+		Type:     schema.FieldTypeDate,
+		Required: true,
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "gitCommit",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(100),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "gitRef",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(500),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "trigger",
+		Type:     schema.FieldTypeSelect,
+		Required: true,
+		Options: &schema.SelectOptions{
+			MaxSelect: 1,
+			Values: []string{
+				string(SyncTriggerManual),
+				string(SyncTriggerNomadEvent),
+				string(SyncTriggerPoll),
+				string(SyncTriggerSourceUpdate),
+				string(SyncTriggerWebhook),
+			},
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "dryRun",
+		Type:     schema.FieldTypeBool,
+		Required: false,
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "result",
+		Type:     schema.FieldTypeText,
+		Required: false,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(100),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "message",
+		Type:     schema.FieldTypeText,
+		Required: false,
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "error",
+		Type:     schema.FieldTypeText,
+		Required: false,
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "jobs",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Options: &schema.JsonOptions{
+			// 5 MB
+			MaxSize: 5242880,
+		},
+	})
+
+	// validate and submit (internally it calls app.Dao().SaveCollection(collection) in a transaction)
+	if err := form.Submit(); err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+func SyncRunFromRecord(record *models.Record) *SyncRun {
+	run := &SyncRun{
+		ID:         record.Id,
+		SourceID:   record.GetString("source"),
+		StartedAt:  record.GetDateTime("startedAt").Time(),
+		FinishedAt: record.GetDateTime("finishedAt").Time(),
+		GitCommit:  record.GetString("gitCommit"),
+		GitRef:     record.GetString("gitRef"),
+		Trigger:    SyncTrigger(record.GetString("trigger")),
+		DryRun:     record.GetBool("dryRun"),
+		Result:     record.GetString("result"),
+		Message:    record.GetString("message"),
+		Error:      record.GetString("error"),
+	}
+	err := record.UnmarshalJSONField("jobs", &run.Jobs)
+	if err != nil {
+		fmt.Printf("Could not unmarshal jobs field:%v", err)
+	}
+	return run
+}
@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/cronexpr"
+)
+
+type SyncWindowKind string
+
+const (
+	// SyncWindowAllow allows automatic syncs while active, if a source has allow windows it is blocked outside of them
+	SyncWindowAllow SyncWindowKind = "allow"
+	// SyncWindowDeny blocks automatic syncs while active, deny windows win over allow windows
+	SyncWindowDeny SyncWindowKind = "deny"
+)
+
+// SyncWindow A recurring time window in which automatic syncs are allowed or denied
+type SyncWindow struct {
+	Kind SyncWindowKind `json:"kind"`
+
+	// cron expression of the window start, e.g. "0 18 * * 5" for friday evening
+	Schedule string `json:"schedule"`
+
+	// how long the window stays active after its start, e.g. "60h"
+	Duration string `json:"duration"`
+
+	// time zone of the schedule, e.g. "Europe/Berlin", defaults to UTC
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Active reports whether the window started within its duration before t
+func (w SyncWindow) Active(t time.Time) (bool, error) {
+	expr, err := cronexpr.Parse(w.Schedule)
+	if err != nil {
+		return false, fmt.Errorf("invalid sync window schedule %q:%v", w.Schedule, err)
+	}
+	d, err := time.ParseDuration(w.Duration)
+	if err != nil || d <= 0 {
+		return false, fmt.Errorf("invalid sync window duration %q", w.Duration)
+	}
+	loc := time.UTC
+	if w.TimeZone != "" {
+		loc, err = time.LoadLocation(w.TimeZone)
+		if err != nil {
+			return false, fmt.Errorf("invalid sync window time zone %q:%v", w.TimeZone, err)
+		}
+	}
+	start := expr.Next(t.Add(-d).In(loc))
+	return !start.IsZero() && !start.After(t), nil
+}
+
+// SyncAllowed reports whether automatic syncs are allowed at t by the given windows
+func SyncAllowed(windows []SyncWindow, t time.Time) (bool, error) {
+	hasAllow := false
+	allowed := false
+	for _, w := range windows {
+		active, err := w.Active(t)
+		if err != nil {
+			return false, err
+		}
+		switch w.Kind {
+		case SyncWindowDeny:
+			if active {
+				return false, nil
+			}
+		case SyncWindowAllow:
+			hasAllow = true
+			allowed = allowed || active
+		default:
+			return false, fmt.Errorf("invalid sync window kind %q", w.Kind)
+		}
+	}
+	return !hasAllow || allowed, nil
+}
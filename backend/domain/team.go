@@ -3,6 +3,7 @@ package domain
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/forms"
@@ -24,6 +25,9 @@ type Team struct {
 	External bool `json:"external"`
 
 	MemberIDs []string `json:"members"`
+
+	// windows in which automatic syncs of the team's sources are allowed or denied
+	SyncWindows []SyncWindow `json:"syncWindows,omitempty"`
 }
 
 func (t *Team) UpsertUser(ctx context.Context, userID string) {
@@ -96,6 +100,14 @@ func initTeamCollection(app core.App, usersCollection *models.Collection) (*mode
 			CollectionId: usersCollection.Id,
 		},
 	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "syncWindows",
+		Type:     schema.FieldTypeJson,
+		Required: false,
+		Options: &schema.JsonOptions{
+			MaxSize: 65536,
+		},
+	})
 
 	// validate and submit (internally it calls app.Dao().SaveCollection(collection) in a transaction)
 	if err := form.Submit(); err != nil {
@@ -103,3 +115,17 @@ func initTeamCollection(app core.App, usersCollection *models.Collection) (*mode
 	}
 	return collection, nil
 }
+
+func TeamFromRecord(record *models.Record) *Team {
+	t := &Team{
+		ID:        record.Id,
+		Name:      record.GetString("name"),
+		External:  record.GetBool("external"),
+		MemberIDs: record.GetStringSlice("members"),
+	}
+	err := record.UnmarshalJSONField("syncWindows", &t.SyncWindows)
+	if err != nil {
+		fmt.Printf("Could not unmarshal syncWindows field:%v", err)
+	}
+	return t
+}
@@ -0,0 +1,103 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/models/schema"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// WebhookSecret verifies incoming git push webhooks of the sources using it
+type WebhookSecret struct {
+
+	// id
+	// Read Only: true
+	ID string `json:"id,omitempty"`
+
+	// name
+	// Required: true
+	Name string `json:"name"`
+
+	// created
+	// Read Only: true
+	Created time.Time `json:"timestamp,omitempty"`
+
+	// secret
+	// Required: true
+	Secret string `json:"secret"`
+
+	// teamID of owner
+	TeamID string `json:"teamID,omitempty"`
+}
+
+func initWebhookSecretCollection(app core.App,
+	teamsCollection *models.Collection) (*models.Collection, error) {
+
+	collection, err := app.Dao().FindCollectionByNameOrId("webhook_secrets")
+
+	if err == sql.ErrNoRows {
+		collection = &models.Collection{}
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	form := forms.NewCollectionUpsert(app, collection)
+	form.Name = "webhook_secrets"
+	form.Type = models.CollectionTypeBase
+	form.ListRule = types.Pointer("@request.auth.id != '' && (team = '' || team.members.id ?= @request.auth.id)")
+	form.ViewRule = types.Pointer("@request.auth.id != '' && (team = '' || team.members.id ?= @request.auth.id)")
+	form.CreateRule = types.Pointer("@request.auth.id != ''")
+	form.UpdateRule = types.Pointer("@request.auth.id != '' && (team = '' || team.members.id ?= @request.auth.id)")
+	form.DeleteRule = types.Pointer("@request.auth.id != '' && (team = '' || team.members.id ?= @request.auth.id)")
+	form.Indexes = types.JsonArray[string]{
+		"create unique index webhook_secret_unique on webhook_secrets (name)",
+	}
+
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "name",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(100),
+		},
+	})
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "secret",
+		Type:     schema.FieldTypeText,
+		Required: true,
+		Options: &schema.TextOptions{
+			Max: types.Pointer(200),
+		},
+	})
+	max := 1
+	addOrUpdateField(form, &schema.SchemaField{
+		Name:     "team",
+		Type:     schema.FieldTypeRelation,
+		Required: false, // optional, if not set every team can see this
+		Options: &schema.RelationOptions{
+			CollectionId: teamsCollection.Id,
+			MaxSelect:    &max,
+		},
+	})
+
+	// validate and submit (internally it calls app.Dao().SaveCollection(collection) in a transaction)
+	if err := form.Submit(); err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+func WebhookSecretFromRecord(record *models.Record) *WebhookSecret {
+	return &WebhookSecret{
+		ID:      record.Id,
+		Name:    record.GetString("name"),
+		Created: record.Created.Time(),
+		Secret:  record.GetString("secret"),
+		TeamID:  record.GetString("team"),
+	}
+}
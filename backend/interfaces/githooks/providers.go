@@ -0,0 +1,169 @@
+package githooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nomad-ops/nomad-ops/backend/utils/errors"
+)
+
+type githubPush struct {
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+func parseGitHub(header http.Header, body []byte) (*PushEvent, error) {
+	isPush, err := requireEvent(header, "X-GitHub-Event", "push")
+	if err != nil || !isPush {
+		return nil, err
+	}
+	return parseGitHubLike(body)
+}
+
+func parseGitea(header http.Header, body []byte) (*PushEvent, error) {
+	isPush, err := requireEvent(header, "X-Gitea-Event", "push")
+	if err != nil || !isPush {
+		return nil, err
+	}
+	// gitea uses the same payload as github
+	return parseGitHubLike(body)
+}
+
+func parseGitHubLike(body []byte) (*PushEvent, error) {
+	var p githubPush
+	err := json.Unmarshal(body, &p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInvalid, err)
+	}
+	if p.Repository.FullName == "" || p.Ref == "" {
+		return nil, fmt.Errorf("%w: missing repository or ref", errors.ErrInvalid)
+	}
+	return &PushEvent{
+		Repo:   p.Repository.FullName,
+		Refs:   []string{p.Ref},
+		Commit: p.After,
+	}, nil
+}
+
+type gitlabPush struct {
+	Ref         string `json:"ref"`
+	CheckoutSha string `json:"checkout_sha"`
+	Project     struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+}
+
+func parseGitLab(header http.Header, body []byte) (*PushEvent, error) {
+	isPush, err := requireEvent(header, "X-Gitlab-Event", "Push Hook", "Tag Push Hook")
+	if err != nil || !isPush {
+		return nil, err
+	}
+	var p gitlabPush
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errors.ErrInvalid, err)
+	}
+	if p.Project.PathWithNamespace == "" || p.Ref == "" {
+		return nil, fmt.Errorf("%w: missing project or ref", errors.ErrInvalid)
+	}
+	return &PushEvent{
+		Repo:   p.Project.PathWithNamespace,
+		Refs:   []string{p.Ref},
+		Commit: p.CheckoutSha,
+	}, nil
+}
+
+// bitbucketCloudPush is sent with the event key "repo:push"
+type bitbucketCloudPush struct {
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type   string `json:"type"`
+				Name   string `json:"name"`
+				Target struct {
+					Hash string `json:"hash"`
+				} `json:"target"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+}
+
+// bitbucketServerPush is sent with the event key "repo:refs_changed"
+type bitbucketServerPush struct {
+	Repository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+	} `json:"repository"`
+	Changes []struct {
+		RefID  string `json:"refId"`
+		ToHash string `json:"toHash"`
+		Type   string `json:"type"`
+	} `json:"changes"`
+}
+
+func parseBitbucket(header http.Header, body []byte) (*PushEvent, error) {
+	key := header.Get("X-Event-Key")
+	switch key {
+	case "repo:push":
+		var p bitbucketCloudPush
+		err := json.Unmarshal(body, &p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrInvalid, err)
+		}
+		ev := &PushEvent{
+			Repo: p.Repository.FullName,
+		}
+		for _, c := range p.Push.Changes {
+			if c.New == nil {
+				// ref was deleted
+				continue
+			}
+			switch c.New.Type {
+			case "branch":
+				ev.Refs = append(ev.Refs, "refs/heads/"+c.New.Name)
+			case "tag":
+				ev.Refs = append(ev.Refs, "refs/tags/"+c.New.Name)
+			default:
+				continue
+			}
+			ev.Commit = c.New.Target.Hash
+		}
+		if ev.Repo == "" || len(ev.Refs) == 0 {
+			return nil, fmt.Errorf("%w: missing repository or changes", errors.ErrInvalid)
+		}
+		return ev, nil
+	case "repo:refs_changed":
+		var p bitbucketServerPush
+		err := json.Unmarshal(body, &p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errors.ErrInvalid, err)
+		}
+		ev := &PushEvent{
+			Repo: p.Repository.Project.Key + "/" + p.Repository.Slug,
+		}
+		for _, c := range p.Changes {
+			if c.Type == "DELETE" {
+				continue
+			}
+			ev.Refs = append(ev.Refs, c.RefID)
+			ev.Commit = c.ToHash
+		}
+		if p.Repository.Slug == "" || len(ev.Refs) == 0 {
+			return nil, fmt.Errorf("%w: missing repository or changes", errors.ErrInvalid)
+		}
+		return ev, nil
+	case "":
+		return nil, fmt.Errorf("%w: missing X-Event-Key header", errors.ErrInvalid)
+	default:
+		return nil, nil
+	}
+}
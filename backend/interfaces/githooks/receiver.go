@@ -0,0 +1,204 @@
+package githooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/nomad-ops/nomad-ops/backend/application"
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/errors"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+// PushEvent is the provider independent result of parsing a push payload
+type PushEvent struct {
+	// Repo is the path of the repository, e.g. "org/repo"
+	Repo string
+	// Refs contains the full names of all pushed refs, e.g. "refs/heads/main"
+	Refs []string
+	// Commit is the new head commit, if provided
+	Commit string
+}
+
+// Verifier checks the authenticity of a payload with the given secret
+type Verifier func(header http.Header, body []byte, secret string) bool
+
+type provider struct {
+	parse  func(header http.Header, body []byte) (*PushEvent, error)
+	verify Verifier
+}
+
+var providers = map[string]provider{
+	"github": {
+		parse:  parseGitHub,
+		verify: verifyHMACHeader("X-Hub-Signature-256", "sha256="),
+	},
+	"gitea": {
+		parse:  parseGitea,
+		verify: verifyHMACHeader("X-Gitea-Signature", ""),
+	},
+	"gitlab": {
+		parse:  parseGitLab,
+		verify: verifyTokenHeader("X-Gitlab-Token"),
+	},
+	"bitbucket": {
+		parse:  parseBitbucket,
+		verify: verifyHMACHeader("X-Hub-Signature", "sha256="),
+	},
+}
+
+type Syncer interface {
+	ListWatchedSources(ctx context.Context, repo, ref string) []*domain.Source
+	SyncSourceByID(ctx context.Context, id string, opts application.SyncSourceOptions) error
+}
+
+type ReceiverConfig struct {
+	// Secret is used for all sources that do not define their own webhook secret
+	Secret string
+}
+
+type Receiver struct {
+	ctx        context.Context
+	logger     log.Logger
+	cfg        ReceiverConfig
+	syncer     Syncer
+	secretRepo application.WebhookSecretRepo
+}
+
+func CreateReceiver(ctx context.Context,
+	logger log.Logger,
+	cfg ReceiverConfig,
+	syncer Syncer,
+	secretRepo application.WebhookSecretRepo) (*Receiver, error) {
+	t := &Receiver{
+		ctx:        ctx,
+		logger:     logger,
+		cfg:        cfg,
+		syncer:     syncer,
+		secretRepo: secretRepo,
+	}
+
+	return t, nil
+}
+
+// HandlePush parses the payload of the given provider, verifies it against the secret of every
+// matching source and triggers a sync for each verified source.
+// The ids of the triggered sources are returned. Pushes to repositories no source is watching
+// are unauthorized as well, so the response does not reveal which repositories are watched.
+func (r *Receiver) HandlePush(ctx context.Context, providerName string, header http.Header, body []byte) ([]string, error) {
+	p, ok := providers[strings.ToLower(providerName)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown provider %s", errors.ErrInvalid, providerName)
+	}
+
+	ev, err := p.parse(header, body)
+	if err != nil {
+		r.logger.LogError(ctx, "Could not parse %s payload:%v", providerName, err)
+		return nil, err
+	}
+	if ev == nil {
+		// not a push, e.g. a ping
+		return nil, nil
+	}
+	r.logger.LogInfo(ctx, "Received %s push for %s on %v", providerName, ev.Repo, ev.Refs)
+
+	var srcs []*domain.Source
+	seen := map[string]bool{}
+	for _, ref := range ev.Refs {
+		for _, src := range r.syncer.ListWatchedSources(ctx, ev.Repo, ref) {
+			if seen[src.ID] {
+				continue
+			}
+			seen[src.ID] = true
+			srcs = append(srcs, src)
+		}
+	}
+	if len(srcs) == 0 {
+		r.logger.LogInfo(ctx, "Ignoring push for %s as no source is watching it", ev.Repo)
+		return nil, errors.ErrUnauthorized
+	}
+
+	var ids []string
+	for _, src := range srcs {
+		secret := r.cfg.Secret
+		if src.WebhookSecretID != "" {
+			s, err := r.secretRepo.GetWebhookSecret(ctx, src.WebhookSecretID)
+			if err != nil {
+				r.logger.LogError(ctx, "Could not GetWebhookSecret of source %s:%v", src.ID, err)
+				continue
+			}
+			secret = s.Secret
+		}
+		if secret == "" {
+			r.logger.LogInfo(ctx, "Ignoring push for source %s as no webhook secret is configured", src.ID)
+			continue
+		}
+		if !p.verify(header, body, secret) {
+			r.logger.LogInfo(ctx, "Ignoring push for source %s as the signature does not match", src.ID)
+			continue
+		}
+		ids = append(ids, src.ID)
+	}
+	if len(ids) == 0 {
+		return nil, errors.ErrUnauthorized
+	}
+
+	for _, id := range ids {
+		// syncing blocks until the watcher picks it up, providers expect a fast response
+		go func(id string) {
+			err := r.syncer.SyncSourceByID(r.ctx, id, application.SyncSourceOptions{
+				Trigger: domain.SyncTriggerWebhook,
+			})
+			if err != nil {
+				r.logger.LogError(r.ctx, "Could not SyncSourceByID %s on push:%v", id, err)
+			}
+		}(id)
+	}
+
+	return ids, nil
+}
+
+func verifyHMACHeader(headerName, prefix string) Verifier {
+	return func(header http.Header, body []byte, secret string) bool {
+		sig := header.Get(headerName)
+		if !strings.HasPrefix(sig, prefix) {
+			return false
+		}
+		got, err := hex.DecodeString(strings.TrimPrefix(sig, prefix))
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	}
+}
+
+func verifyTokenHeader(headerName string) Verifier {
+	return func(header http.Header, body []byte, secret string) bool {
+		token := header.Get(headerName)
+		if token == "" {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+}
+
+func requireEvent(header http.Header, headerName string, accepted ...string) (bool, error) {
+	ev := header.Get(headerName)
+	if ev == "" {
+		return false, fmt.Errorf("%w: missing %s header", errors.ErrInvalid, headerName)
+	}
+	for _, a := range accepted {
+		if ev == a {
+			return true, nil
+		}
+	}
+	return false, nil
+}
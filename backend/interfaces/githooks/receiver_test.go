@@ -0,0 +1,154 @@
+package githooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	goerrors "errors"
+	"net/http"
+	"testing"
+
+	"github.com/nomad-ops/nomad-ops/backend/application"
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/errors"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+type testSyncer struct {
+	srcs   []*domain.Source
+	synced chan string
+}
+
+func (s *testSyncer) ListWatchedSources(ctx context.Context, repo, ref string) []*domain.Source {
+	var res []*domain.Source
+	for _, src := range s.srcs {
+		if src.URL == repo && "refs/heads/"+src.Branch == ref {
+			res = append(res, src)
+		}
+	}
+	return res
+}
+
+func (s *testSyncer) SyncSourceByID(ctx context.Context, id string, opts application.SyncSourceOptions) error {
+	s.synced <- id
+	return nil
+}
+
+type testSecretRepo struct {
+	secrets map[string]string
+}
+
+func (r *testSecretRepo) GetWebhookSecret(ctx context.Context, id string) (*domain.WebhookSecret, error) {
+	secret, ok := r.secrets[id]
+	if !ok {
+		return nil, errors.ErrNotFound
+	}
+	return &domain.WebhookSecret{ID: id, Secret: secret}, nil
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHandlePush(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewSimpleLogger(false, "Test")
+	syncer := &testSyncer{
+		srcs: []*domain.Source{
+			{ID: "own-secret", URL: "org/repo", Branch: "main", WebhookSecretID: "source"},
+			{ID: "global-secret", URL: "org/repo", Branch: "main"},
+			// never falls back to the global secret
+			{ID: "deleted-secret", URL: "org/repo", Branch: "main", WebhookSecretID: "deleted"},
+			{ID: "other-branch", URL: "org/repo", Branch: "dev"},
+		},
+		synced: make(chan string, 10),
+	}
+	r, err := CreateReceiver(ctx, logger, ReceiverConfig{
+		Secret: "global",
+	}, syncer, &testSecretRepo{secrets: map[string]string{"source": "source-secret"}})
+	if err != nil {
+		t.Fatalf("Could not CreateReceiver:%v", err)
+	}
+
+	body := []byte(`{"ref":"refs/heads/main","after":"abc","repository":{"full_name":"org/repo"}}`)
+
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "push")
+	header.Set("X-Hub-Signature-256", "sha256="+sign("global", body))
+	ids, err := r.HandlePush(ctx, "github", header, body)
+	if err != nil {
+		t.Fatalf("Could not HandlePush:%v", err)
+	}
+	if len(ids) != 1 || ids[0] != "global-secret" {
+		t.Fatalf("Expected only the source using the global secret to be synced, got %v", ids)
+	}
+	if id := <-syncer.synced; id != "global-secret" {
+		t.Fatalf("Expected global-secret to be synced, got %s", id)
+	}
+
+	header.Set("X-Hub-Signature-256", "sha256="+sign("wrong", body))
+	_, err = r.HandlePush(ctx, "github", header, body)
+	if err != errors.ErrUnauthorized {
+		t.Fatalf("Expected ErrUnauthorized, got %v", err)
+	}
+
+	// signed pushes to unwatched repositories are not distinguished from unsigned ones
+	unwatched := []byte(`{"ref":"refs/heads/main","after":"abc","repository":{"full_name":"org/other"}}`)
+	header.Set("X-Hub-Signature-256", "sha256="+sign("global", unwatched))
+	_, err = r.HandlePush(ctx, "github", header, unwatched)
+	if err != errors.ErrUnauthorized {
+		t.Fatalf("Expected ErrUnauthorized for an unwatched repository, got %v", err)
+	}
+
+	_, err = r.HandlePush(ctx, "unknown", header, body)
+	if !goerrors.Is(err, errors.ErrInvalid) {
+		t.Fatalf("Expected ErrInvalid for an unknown provider, got %v", err)
+	}
+
+	header = http.Header{}
+	header.Set("X-Gitlab-Event", "Push Hook")
+	header.Set("X-Gitlab-Token", "source-secret")
+	ids, err = r.HandlePush(ctx, "gitlab", header,
+		[]byte(`{"ref":"refs/heads/main","checkout_sha":"abc","project":{"path_with_namespace":"org/repo"}}`))
+	if err != nil {
+		t.Fatalf("Could not HandlePush:%v", err)
+	}
+	if len(ids) != 1 || ids[0] != "own-secret" {
+		t.Fatalf("Expected only the source with its own secret to be synced, got %v", ids)
+	}
+	<-syncer.synced
+}
+
+func TestParseBitbucket(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Event-Key", "repo:push")
+	ev, err := parseBitbucket(header, []byte(`{
+	"repository": {"full_name": "org/repo"},
+	"push": {"changes": [
+		{"new": {"type": "branch", "name": "main", "target": {"hash": "abc"}}},
+		{"new": null},
+		{"new": {"type": "tag", "name": "v1.0.0", "target": {"hash": "def"}}}
+	]}
+}`))
+	if err != nil {
+		t.Fatalf("Could not parse:%v", err)
+	}
+	if ev.Repo != "org/repo" || len(ev.Refs) != 2 || ev.Refs[0] != "refs/heads/main" || ev.Refs[1] != "refs/tags/v1.0.0" {
+		t.Fatalf("Unexpected event:%+v", ev)
+	}
+
+	header.Set("X-Event-Key", "repo:refs_changed")
+	ev, err = parseBitbucket(header, []byte(`{
+	"repository": {"slug": "repo", "project": {"key": "ORG"}},
+	"changes": [{"refId": "refs/heads/main", "toHash": "abc", "type": "UPDATE"}]
+}`))
+	if err != nil {
+		t.Fatalf("Could not parse:%v", err)
+	}
+	if ev.Repo != "ORG/repo" || len(ev.Refs) != 1 || ev.Commit != "abc" {
+		t.Fatalf("Unexpected event:%+v", ev)
+	}
+}
@@ -0,0 +1,104 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+const defaultGitHubAPIURL = "https://api.github.com"
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// appTokenCache requests and caches GitHub App installation tokens
+type appTokenCache struct {
+	logger log.Logger
+	client *http.Client
+	lock   sync.Mutex
+	tokens map[string]*installationToken // by credential id
+}
+
+func newAppTokenCache(logger log.Logger) *appTokenCache {
+	return &appTokenCache{
+		logger: logger,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		tokens: map[string]*installationToken{},
+	}
+}
+
+// GetToken returns a cached installation token that is valid for at least another minute
+// or requests a new one
+func (c *appTokenCache) GetToken(ctx context.Context, cred *domain.Credential) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if t, ok := c.tokens[cred.ID]; ok && time.Until(t.ExpiresAt) > time.Minute {
+		return t.Token, nil
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(cred.PrivateKey))
+	if err != nil {
+		c.logger.LogError(ctx, "Could not parse private key of GitHub App %s:%v", cred.AppID, err)
+		return "", err
+	}
+
+	now := time.Now()
+	appJWT, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		// allow some clock drift
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(9 * time.Minute)),
+		Issuer:    cred.AppID,
+	}).SignedString(key)
+	if err != nil {
+		return "", err
+	}
+
+	apiURL := cred.APIURL
+	if apiURL == "" {
+		apiURL = defaultGitHubAPIURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/app/installations/%s/access_tokens", strings.TrimSuffix(apiURL, "/"), cred.InstallationID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+appJWT)
+
+	resp, err := c.client.Do(req)
+	if resp != nil && resp.Body != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		respB, _ := httputil.DumpResponse(resp, true)
+		c.logger.LogError(ctx, "Could not request installation token for GitHub App %s:%v", cred.AppID, string(respB))
+		return "", fmt.Errorf("could not request installation token for GitHub App %s: %s", cred.AppID, resp.Status)
+	}
+
+	t := &installationToken{}
+	err = json.NewDecoder(resp.Body).Decode(t)
+	if err != nil {
+		return "", err
+	}
+	c.tokens[cred.ID] = t
+
+	return t.Token, nil
+}
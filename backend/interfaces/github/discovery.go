@@ -0,0 +1,267 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/nomad-ops/nomad-ops/backend/application"
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/interfaces/jobrender"
+)
+
+// jobFormat determines the format of a job file by its extension
+func jobFormat(file string) application.JobFormat {
+	if strings.HasSuffix(strings.ToLower(file), ".json") {
+		return application.JobFormatJSON
+	}
+	return application.JobFormatHCL
+}
+
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// validateGlob returns an error if the pattern is malformed
+func validateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		_, err := path.Match(segment, "")
+		if err != nil {
+			return fmt.Errorf("invalid pattern '%s': %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob matches a slash separated path against a pattern in which "**" matches any number of directories
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchGlob(repoFilePath(p), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkFiles returns the paths of all files below dir
+func walkFiles(tree *object.Tree, dir string) ([]string, error) {
+	if dir != "" {
+		var err error
+		tree, err = tree.Tree(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	var files []string
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !entry.Mode.IsFile() {
+			continue
+		}
+		files = append(files, path.Join(dir, name))
+	}
+	return files, nil
+}
+
+// findJobFiles returns the paths of all job files of the source in the repo.
+// Path can be a file, a directory or a glob pattern like "apps/**/*.nomad.hcl".
+// Directories are only searched recursively if include patterns are set,
+// otherwise only their top level .nomad and .hcl files are used.
+// JSON jobs have to be selected by the path or include patterns, directories often hold other json files
+func (g *GitProvider) findJobFiles(ctx context.Context, tree *object.Tree, src *domain.Source, ignored map[string]bool) ([]string, error) {
+	repoPath := repoFilePath(src.Path)
+
+	for _, p := range append(append([]string{repoPath}, src.Include...), src.Exclude...) {
+		err := validateGlob(repoFilePath(p))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var candidates []string
+	switch {
+	case isGlob(repoPath):
+		files, err := walkFiles(tree, "")
+		if err != nil {
+			g.logger.LogError(ctx, "Could not walk repo:%v", err)
+			return nil, err
+		}
+		for _, f := range files {
+			if matchGlob(repoPath, f) {
+				candidates = append(candidates, f)
+			}
+		}
+	case repoPath != "":
+		entry, err := tree.FindEntry(repoPath)
+		if err != nil {
+			g.logger.LogError(ctx, "Could not find Path in repo:%v - %v", src.Path, err)
+			return nil, err
+		}
+		if entry.Mode.IsFile() {
+			// an explicit file is always used
+			return []string{repoPath}, nil
+		}
+		fallthrough
+	default:
+		if len(src.Include) > 0 {
+			files, err := walkFiles(tree, repoPath)
+			if err != nil {
+				g.logger.LogError(ctx, "Could not walk %s:%v", src.Path, err)
+				return nil, err
+			}
+			candidates = files
+			break
+		}
+
+		dir := tree
+		if repoPath != "" {
+			var err error
+			dir, err = tree.Tree(repoPath)
+			if err != nil {
+				g.logger.LogError(ctx, "tree.Tree failed:%v", err)
+				return nil, err
+			}
+		}
+		for _, entry := range dir.Entries {
+			if !entry.Mode.IsFile() {
+				continue
+			}
+			if !strings.HasSuffix(entry.Name, ".nomad") && !strings.HasSuffix(entry.Name, ".hcl") {
+				g.logger.LogTrace(ctx, "ignoring file:%v", entry.Name)
+				continue
+			}
+			candidates = append(candidates, path.Join(repoPath, entry.Name))
+		}
+	}
+
+	var files []string
+	for _, f := range candidates {
+		if ignored[f] {
+			g.logger.LogTrace(ctx, "ignoring file:%v", f)
+			continue
+		}
+		if len(src.Include) > 0 && !matchAnyGlob(src.Include, f) {
+			continue
+		}
+		if matchAnyGlob(src.Exclude, f) {
+			g.logger.LogTrace(ctx, "excluding file:%v", f)
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readJobFiles returns the content of all job files of the source by their path
+func (g *GitProvider) readJobFiles(ctx context.Context, tree *object.Tree, src *domain.Source) (map[string]string, error) {
+	ignored := map[string]bool{}
+	for _, f := range append(append([]string{}, src.VarFiles...), src.ValuesFiles...) {
+		ignored[repoFilePath(f)] = true
+	}
+	files, err := g.findJobFiles(ctx, tree, src, ignored)
+	if err != nil {
+		return nil, err
+	}
+
+	var tplOpts jobrender.TemplateOptions
+	if src.Template {
+		tplOpts.LeftDelim, tplOpts.RightDelim, err = jobrender.ParseDelims(src.TemplateDelims)
+		if err != nil {
+			return nil, err
+		}
+		valuesFiles, err := readFiles(ctx, tree, src.ValuesFiles)
+		if err != nil {
+			g.logger.LogError(ctx, "Could not read values files:%v", err)
+			return nil, err
+		}
+		tplOpts.Values, err = jobrender.LoadValues(valuesFiles, src.Values)
+		if err != nil {
+			g.logger.LogError(ctx, "Could not load values:%v", err)
+			return nil, err
+		}
+	}
+
+	jobs := map[string]string{}
+	for _, file := range files {
+		f, err := tree.File(file)
+		if err != nil {
+			g.logger.LogError(ctx, "tree.File(%s) failed:%v", file, err)
+			return nil, err
+		}
+		content, err := f.Contents()
+		if err != nil {
+			g.logger.LogError(ctx, "tree.File(%s).Contents failed:%v", file, err)
+			return nil, err
+		}
+		if src.Template {
+			content, err = jobrender.RenderTemplate(file, content, tplOpts)
+			if err != nil {
+				g.logger.LogError(ctx, "Could not render template %s:%v", file, err)
+				return nil, err
+			}
+		}
+		jobs[file] = content
+	}
+	return jobs, nil
+}
+
+// readFiles returns the content of the files in the repo in the given order
+func readFiles(ctx context.Context, tree *object.Tree, files []string) ([]jobrender.VarFile, error) {
+	var res []jobrender.VarFile
+	for _, file := range files {
+		f, err := tree.File(repoFilePath(file))
+		if err != nil {
+			return nil, fmt.Errorf("could not find %s: %v", file, err)
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, jobrender.VarFile{
+			Name:    file,
+			Content: content,
+		})
+	}
+	return res, nil
+}
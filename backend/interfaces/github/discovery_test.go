@@ -0,0 +1,95 @@
+package github
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{pattern: "apps/*/job.nomad.hcl", name: "apps/web/job.nomad.hcl", match: true},
+		{pattern: "apps/*/job.nomad.hcl", name: "apps/web/v2/job.nomad.hcl", match: false},
+		{pattern: "apps/**/job.nomad.hcl", name: "apps/web/v2/job.nomad.hcl", match: true},
+		{pattern: "apps/**/job.nomad.hcl", name: "apps/job.nomad.hcl", match: true},
+		{pattern: "**/*.nomad", name: "a.nomad", match: true},
+		{pattern: "**/*.nomad", name: "a/b/c.nomad", match: true},
+		{pattern: "**", name: "a/b/c.nomad", match: true},
+		{pattern: "apps/**", name: "other/a.nomad", match: false},
+		{pattern: "apps/web?.hcl", name: "apps/web1.hcl", match: true},
+	} {
+		if matchGlob(tc.pattern, tc.name) != tc.match {
+			t.Errorf("Expected matchGlob(%s, %s) to be %v", tc.pattern, tc.name, tc.match)
+		}
+	}
+	if validateGlob("apps/[") == nil {
+		t.Errorf("Expected an error for a malformed pattern")
+	}
+}
+
+func TestFindJobFiles(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewSimpleLogger(false, "Test")
+
+	remoteDir := t.TempDir()
+	commitFiles(t, remoteDir, map[string]string{
+		"apps/web/job.nomad.hcl":      "web",
+		"apps/api/job.nomad.hcl":      "api",
+		"apps/api/vars.hcl":           "vars",
+		"apps/legacy/job.nomad.hcl":   "legacy",
+		"apps/deep/a/b/job.nomad.hcl": "deep",
+		"apps/top.nomad":              "top",
+		"apps/gen.nomad.json":         "gen",
+		"apps/package.json":           "package",
+		"README.md":                   "readme",
+	})
+
+	g, err := CreateGitProvider(ctx, logger, GitProviderConfig{
+		HostKeyPolicy: HostKeyPolicyStrict,
+	}, testParser{}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateGitProvider:%v", err)
+	}
+
+	for _, tc := range []struct {
+		path    string
+		include []string
+		exclude []string
+		jobs    []string
+	}{
+		{path: "apps", jobs: []string{"top"}},
+		{path: "apps", include: []string{"apps/*.nomad.json"}, jobs: []string{"gen"}},
+		{path: "apps/*.nomad.json", jobs: []string{"gen"}},
+		{path: "apps/*/job.nomad.hcl", jobs: []string{"api", "legacy", "web"}},
+		{path: "apps/**/job.nomad.hcl", exclude: []string{"apps/legacy/**"}, jobs: []string{"api", "deep", "web"}},
+		{path: "apps", include: []string{"**/*.nomad.hcl", "**/*.nomad"}, exclude: []string{"apps/deep/**"}, jobs: []string{"api", "legacy", "top", "web"}},
+		{path: "apps/web/job.nomad.hcl", exclude: []string{"**"}, jobs: []string{"web"}},
+	} {
+		desiredState, err := g.FetchDesiredState(ctx, &domain.Source{
+			ID:      "test",
+			URL:     "file://" + remoteDir,
+			Branch:  "master",
+			Path:    tc.path,
+			Include: tc.include,
+			Exclude: tc.exclude,
+		})
+		if err != nil {
+			t.Fatalf("%s: could not FetchDesiredState:%v", tc.path, err)
+		}
+		if len(desiredState.Jobs) != len(tc.jobs) {
+			t.Errorf("%s: expected jobs %v, got %v", tc.path, tc.jobs, desiredState.Jobs)
+			continue
+		}
+		for _, j := range tc.jobs {
+			if desiredState.Jobs[j] == nil {
+				t.Errorf("%s: expected jobs %v, got %v", tc.path, tc.jobs, desiredState.Jobs)
+			}
+		}
+	}
+}
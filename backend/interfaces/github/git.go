@@ -3,19 +3,21 @@ package github
 import (
 	"context"
 	"crypto/md5"
+	"encoding/hex"
 	"fmt"
-	"io"
+	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
-	sshstd "golang.org/x/crypto/ssh"
-
-	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/go-git/go-git/v5/storage/memory"
 
@@ -24,192 +26,504 @@ import (
 	"github.com/nomad-ops/nomad-ops/backend/utils/log"
 )
 
+const remoteName = "origin"
+
+// cachedRepo is a bare clone shared by all sources pointing to the same url
+type cachedRepo struct {
+	lock sync.Mutex
+	url  string
+	dir  string
+	repo *git.Repository
+}
+
 type GitProvider struct {
 	ctx      context.Context
 	logger   log.Logger
 	cfg      GitProviderConfig
 	parser   application.JobParser
 	repoLock sync.Mutex
-	repos    map[string]*git.Repository
+	repos    map[string]*cachedRepo // by url
+	srcRepos map[string]string      // source id => url
 	keyRepo  application.KeyRepo
+	credRepo application.CredentialRepo
+	hostRepo application.KnownHostRepo
+	appCache *appTokenCache
 }
 
 type GitProviderConfig struct {
 	ReposDir string
+	// if true, repos are stored in ReposDir and reused across restarts instead of being kept in memory
+	Persist bool
+	// optional known_hosts file, used in addition to the stored known hosts
+	KnownHostsFile string
+	HostKeyPolicy  HostKeyPolicy
 }
 
 func CreateGitProvider(ctx context.Context,
 	logger log.Logger,
 	cfg GitProviderConfig,
 	parser application.JobParser,
-	keyRepo application.KeyRepo) (*GitProvider, error) {
+	keyRepo application.KeyRepo,
+	credRepo application.CredentialRepo,
+	hostRepo application.KnownHostRepo) (*GitProvider, error) {
+
+	switch cfg.HostKeyPolicy {
+	case HostKeyPolicyStrict, HostKeyPolicyTOFU, HostKeyPolicyInsecure:
+	default:
+		return nil, fmt.Errorf("unknown host key policy '%s'", cfg.HostKeyPolicy)
+	}
 
 	t := &GitProvider{
-		ctx:     ctx,
-		logger:  logger,
-		cfg:     cfg,
-		parser:  parser,
-		repos:   map[string]*git.Repository{},
-		keyRepo: keyRepo,
+		ctx:      ctx,
+		logger:   logger,
+		cfg:      cfg,
+		parser:   parser,
+		repos:    map[string]*cachedRepo{},
+		srcRepos: map[string]string{},
+		keyRepo:  keyRepo,
+		credRepo: credRepo,
+		hostRepo: hostRepo,
+		appCache: newAppTokenCache(logger),
 	}
 
 	return t, nil
 }
 
-func (g *GitProvider) FetchDesiredState(ctx context.Context, src *domain.Source) (*application.DesiredState, error) {
+func (g *GitProvider) repoDir(url string) string {
+	return filepath.Join(g.cfg.ReposDir, fmt.Sprintf("%x", md5.Sum([]byte(url))))
+}
+
+// getRepo returns the shared repo of the source's url and releases the repo
+// of a previous url if the source changed its url
+func (g *GitProvider) getRepo(ctx context.Context, src *domain.Source) (*cachedRepo, error) {
 	g.repoLock.Lock()
 	defer g.repoLock.Unlock()
-	var auth transport.AuthMethod
-	if src.DeployKeyID != "" {
 
-		key, err := g.keyRepo.GetKey(ctx, src.DeployKeyID)
+	if oldURL, ok := g.srcRepos[src.ID]; ok && oldURL != src.URL {
+		g.logger.LogInfo(ctx, "Url of source %s changed from %s to %s", src.ID, oldURL, src.URL)
+		delete(g.srcRepos, src.ID)
+		g.releaseRepo(ctx, oldURL)
+	}
+	g.srcRepos[src.ID] = src.URL
+
+	if cr, ok := g.repos[src.URL]; ok {
+		return cr, nil
+	}
+
+	cr := &cachedRepo{
+		url: src.URL,
+	}
+	if !g.cfg.Persist {
+		repo, err := git.Init(memory.NewStorage(), nil)
 		if err != nil {
-			g.logger.LogError(ctx, "Could not GetKey:%v", err)
 			return nil, err
 		}
-
-		publicKeys, err := ssh.NewPublicKeys("git", []byte(key.Value), "")
+		cr.repo = repo
+	} else {
+		cr.dir = filepath.Join(g.repoDir(src.URL), path.Base(src.URL))
+		g.logger.LogTrace(ctx, "RepoDir:%v", cr.dir)
+		repo, err := git.PlainOpen(cr.dir)
+		if err != nil && err != git.ErrRepositoryNotExists {
+			g.logger.LogError(ctx, "Could not open cached repo %s, recreating it:%v", cr.dir, err)
+			err = os.RemoveAll(cr.dir)
+			if err != nil {
+				return nil, err
+			}
+		}
 		if err != nil {
-			g.logger.LogError(ctx, "Could not NewPublicKeys:%v", err)
-			return nil, err
+			repo, err = git.PlainInit(cr.dir, true)
+			if err != nil {
+				g.logger.LogError(ctx, "Could not init repo %s:%v", cr.dir, err)
+				return nil, err
+			}
+		} else {
+			g.logger.LogInfo(ctx, "Reusing cached repo %s for %s", cr.dir, src.URL)
 		}
-
-		publicKeys.HostKeyCallback = sshstd.InsecureIgnoreHostKey()
-		auth = publicKeys
+		cr.repo = repo
 	}
 
-	repoDir := filepath.Join(g.cfg.ReposDir, fmt.Sprintf("%x", md5.Sum([]byte(src.URL))), path.Base(src.URL))
-	g.logger.LogTrace(ctx, "RepoDir:%v", repoDir)
-	var wt *git.Worktree
-	gitInfo := application.GitInfo{}
-	if repo, ok := g.repos[src.ID]; ok {
-		var err error
-		wt, err = repo.Worktree()
-		if err != nil {
-			return nil, err
-		}
-		g.logger.LogTrace(ctx, "Pulling...")
-		err = wt.PullContext(ctx, &git.PullOptions{
-			Auth:          auth,
-			ReferenceName: plumbing.NewBranchReferenceName(src.Branch),
-			SingleBranch:  true,
-			Progress:      nil,
+	_, err := cr.repo.Remote(remoteName)
+	if err == git.ErrRemoteNotFound {
+		_, err = cr.repo.CreateRemote(&config.RemoteConfig{
+			Name: remoteName,
+			URLs: []string{src.URL},
 		})
-		if err == git.NoErrAlreadyUpToDate {
-			g.logger.LogTrace(ctx, "Already up to date")
-		}
-		if err != nil && err != git.NoErrAlreadyUpToDate {
-			g.logger.LogError(ctx, "PullContext failed:%v", err)
-			return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	g.repos[src.URL] = cr
+	return cr, nil
+}
+
+// releaseRepo removes the repo of the given url if no source uses it anymore.
+// repoLock must be held
+func (g *GitProvider) releaseRepo(ctx context.Context, url string) {
+	for _, u := range g.srcRepos {
+		if u == url {
+			// still in use
+			return
 		}
-		g.logger.LogTrace(ctx, "Getting last commit...")
+	}
+	cr, ok := g.repos[url]
+	if !ok {
+		return
+	}
+	delete(g.repos, url)
+	if cr.dir == "" {
+		return
+	}
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	g.logger.LogInfo(ctx, "Removing cached repo %s of %s", cr.dir, url)
+	err := os.RemoveAll(g.repoDir(url))
+	if err != nil {
+		g.logger.LogError(ctx, "Could not remove cached repo %s:%v", cr.dir, err)
+	}
+}
 
-		cIter, err := repo.Log(&git.LogOptions{})
-		if err != nil {
-			g.logger.LogError(ctx, "repo.Log failed:%v", err)
-			return nil, err
+// ReleaseSource drops the cached repo of the source, if no other source uses it
+func (g *GitProvider) ReleaseSource(ctx context.Context, id string) error {
+	g.repoLock.Lock()
+	defer g.repoLock.Unlock()
+	url, ok := g.srcRepos[id]
+	if !ok {
+		return nil
+	}
+	delete(g.srcRepos, id)
+	g.releaseRepo(ctx, url)
+	return nil
+}
+
+// isRepoDirName returns true if name is a hash as used by repoDir
+func isRepoDirName(name string) bool {
+	if len(name) != md5.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil && strings.ToLower(name) == name
+}
+
+// PruneRepos removes all cached repos in ReposDir that are not used by any of the given sources,
+// e.g. because the source was deleted while nomad-ops was not running
+func (g *GitProvider) PruneRepos(ctx context.Context, srcs []*domain.Source) error {
+	if !g.cfg.Persist {
+		return nil
+	}
+	used := map[string]bool{}
+	for _, src := range srcs {
+		used[filepath.Base(g.repoDir(src.URL))] = true
+	}
+	entries, err := os.ReadDir(g.cfg.ReposDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	g.repoLock.Lock()
+	defer g.repoLock.Unlock()
+	for _, e := range entries {
+		// ReposDir may be shared, only remove directories named like our repos
+		if !e.IsDir() || used[e.Name()] || !isRepoDirName(e.Name()) {
+			continue
 		}
-		c, err := cIter.Next()
+		g.logger.LogInfo(ctx, "Removing unused cached repo %s", e.Name())
+		err := os.RemoveAll(filepath.Join(g.cfg.ReposDir, e.Name()))
 		if err != nil {
-			g.logger.LogError(ctx, "cIter.Next failed:%v", err)
-			return nil, err
+			g.logger.LogError(ctx, "Could not remove unused cached repo %s:%v", e.Name(), err)
 		}
-		gitInfo.GitCommit = c.Hash.String()
-		g.logger.LogTrace(ctx, "Getting last commit...%v", gitInfo.GitCommit)
-	} else {
+	}
+	return nil
+}
 
-		repo, err := git.CloneContext(ctx, memory.NewStorage(), memfs.New(), &git.CloneOptions{
-			URL: src.URL,
-			// Depth:         1, https://github.com/go-git/go-git/issues/207
-			NoCheckout:    false,
-			Auth:          auth,
-			Progress:      nil,
-			SingleBranch:  true,
-			ReferenceName: plumbing.NewBranchReferenceName(src.Branch),
-		})
+// fetchRef fetches the ref from the remote into dst and returns its commit
+func (g *GitProvider) fetchRef(ctx context.Context, cr *cachedRepo, src, dst plumbing.ReferenceName, auth transport.AuthMethod) (*object.Commit, error) {
+	g.logger.LogTrace(ctx, "Fetching %s...", src)
+	err := cr.repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: remoteName,
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("+%s:%s", src, dst)),
+		},
+		Auth:     auth,
+		Progress: nil,
+		Tags:     git.NoTags,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		g.logger.LogTrace(ctx, "Already up to date")
+	}
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		g.logger.LogError(ctx, "FetchContext failed:%s - %v", cr.url, err)
+		return nil, err
+	}
+
+	ref, err := cr.repo.Reference(dst, true)
+	if err != nil {
+		g.logger.LogError(ctx, "repo.Reference failed:%v", err)
+		return nil, err
+	}
+	return g.commitObject(ctx, cr, ref.Hash())
+}
+
+// commitObject returns the commit of the hash, annotated tags are peeled
+func (g *GitProvider) commitObject(ctx context.Context, cr *cachedRepo, hash plumbing.Hash) (*object.Commit, error) {
+	tag, err := cr.repo.TagObject(hash)
+	if err == nil {
+		c, err := tag.Commit()
 		if err != nil {
-			g.logger.LogError(ctx, "Could not clone:%s - %v", src.URL, err)
+			g.logger.LogError(ctx, "tag.Commit failed:%v", err)
 			return nil, err
 		}
+		return c, nil
+	}
+	c, err := cr.repo.CommitObject(hash)
+	if err != nil {
+		g.logger.LogError(ctx, "repo.CommitObject failed:%v", err)
+		return nil, err
+	}
+	return c, nil
+}
 
-		wt, err = repo.Worktree()
+// fetchCommit returns the pinned commit, fetching it if it is not known yet
+func (g *GitProvider) fetchCommit(ctx context.Context, cr *cachedRepo, commit string, auth transport.AuthMethod) (*object.Commit, error) {
+	if !plumbing.IsHash(commit) {
+		return nil, fmt.Errorf("'%s' is not a full commit hash", commit)
+	}
+	hash := plumbing.NewHash(commit)
+	c, err := cr.repo.CommitObject(hash)
+	if err == nil {
+		// commits never change, but the repo is shared by all sources of the url.
+		// Make sure the auth of this source can still read the remote
+		remote, err := cr.repo.Remote(remoteName)
 		if err != nil {
-			g.logger.LogError(ctx, "repo.Worktree failed:%v", err)
 			return nil, err
 		}
-		cIter, err := repo.Log(&git.LogOptions{})
+		_, err = remote.ListContext(ctx, &git.ListOptions{
+			Auth: auth,
+		})
 		if err != nil {
-			g.logger.LogError(ctx, "repo.Log failed:%v", err)
+			g.logger.LogError(ctx, "Could not list refs of %s:%v", cr.url, err)
 			return nil, err
 		}
-		c, err := cIter.Next()
-		if err != nil {
-			g.logger.LogError(ctx, "cIter.Next failed:%v", err)
+		return c, nil
+	}
+
+	// not every server allows fetching a commit directly, fall back to fetching all branches
+	err = cr.repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: remoteName,
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("%s:refs/pinned/%s", commit, commit)),
+		},
+		Auth: auth,
+		Tags: git.NoTags,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		g.logger.LogTrace(ctx, "Could not fetch commit %s directly, fetching all branches:%v", commit, err)
+		err = cr.repo.FetchContext(ctx, &git.FetchOptions{
+			RemoteName: remoteName,
+			RefSpecs: []config.RefSpec{
+				config.RefSpec(fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", remoteName)),
+			},
+			Auth: auth,
+			Tags: git.NoTags,
+		})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			g.logger.LogError(ctx, "FetchContext failed:%s - %v", cr.url, err)
 			return nil, err
 		}
-		gitInfo.GitCommit = c.Hash.String()
-		g.repos[src.ID] = repo
 	}
+	return g.commitObject(ctx, cr, hash)
+}
 
-	pathInfo, err := wt.Filesystem.Stat(src.Path)
+// newestTag lists the tags of the remote and returns the newest one matching the semver constraint
+func (g *GitProvider) newestTag(ctx context.Context, cr *cachedRepo, constraint string, auth transport.AuthMethod) (string, error) {
+	remote, err := cr.repo.Remote(remoteName)
 	if err != nil {
-		g.logger.LogError(ctx, "Could not stat Path in repo:%v - %v", src.Path, err)
-		return nil, err
+		return "", err
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth: auth,
+	})
+	if err != nil {
+		g.logger.LogError(ctx, "Could not list refs of %s:%v", cr.url, err)
+		return "", err
+	}
+	var tags []string
+	for _, ref := range refs {
+		if ref.Name().IsTag() {
+			tags = append(tags, ref.Name().Short())
+		}
 	}
+	return newestMatchingTag(constraint, tags)
+}
 
-	desiredState := &application.DesiredState{
-		GitInfo: gitInfo,
-		Jobs:    map[string]*application.JobInfo{},
+// resolveRevision fetches the revision of the source and returns its commit and the resolved ref
+func (g *GitProvider) resolveRevision(ctx context.Context, cr *cachedRepo, src *domain.Source, auth transport.AuthMethod) (*object.Commit, string, error) {
+	switch src.RevisionKind {
+	case "", domain.RevisionKindBranch:
+		c, err := g.fetchRef(ctx, cr,
+			plumbing.NewBranchReferenceName(src.Branch),
+			plumbing.NewRemoteReferenceName(remoteName, src.Branch), auth)
+		return c, plumbing.NewBranchReferenceName(src.Branch).String(), err
+	case domain.RevisionKindTag:
+		ref := plumbing.NewTagReferenceName(src.Branch)
+		c, err := g.fetchRef(ctx, cr, ref, ref, auth)
+		return c, ref.String(), err
+	case domain.RevisionKindSemver:
+		tag, err := g.newestTag(ctx, cr, src.Branch, auth)
+		if err != nil {
+			return nil, "", err
+		}
+		g.logger.LogTrace(ctx, "Resolved %s to tag %s", src.Branch, tag)
+		ref := plumbing.NewTagReferenceName(tag)
+		c, err := g.fetchRef(ctx, cr, ref, ref, auth)
+		return c, ref.String(), err
+	case domain.RevisionKindCommit:
+		c, err := g.fetchCommit(ctx, cr, src.Branch, auth)
+		return c, src.Branch, err
+	default:
+		return nil, "", fmt.Errorf("unknown revision kind '%s'", src.RevisionKind)
 	}
+}
 
-	if pathInfo.IsDir() {
-		fileInfos, err := wt.Filesystem.ReadDir(src.Path)
+func isHTTPURL(url string) bool {
+	u := strings.ToLower(url)
+	return strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://")
+}
+
+// getAuth returns http auth for http(s) urls with a credential and
+// ssh auth for all other urls with a deploy key
+func (g *GitProvider) getAuth(ctx context.Context, src *domain.Source) (transport.AuthMethod, error) {
+	if isHTTPURL(src.URL) {
+		if src.CredentialID == "" {
+			return nil, nil
+		}
+		cred, err := g.credRepo.GetCredential(ctx, src.CredentialID)
 		if err != nil {
-			g.logger.LogError(ctx, "wt.Filesystem.ReadDir failed:%v", err)
+			g.logger.LogError(ctx, "Could not GetCredential:%v", err)
 			return nil, err
 		}
-
-		for _, file := range fileInfos {
-			if !strings.HasSuffix(file.Name(), ".nomad") && !strings.HasSuffix(file.Name(), ".hcl") {
-				g.logger.LogTrace(ctx, "ignoring file:%v", file.Name())
-				continue
+		switch cred.Type {
+		case domain.CredentialTypeBasic:
+			return &githttp.BasicAuth{
+				Username: cred.Username,
+				Password: cred.Password,
+			}, nil
+		case domain.CredentialTypeToken:
+			// most providers accept tokens as the password of basic auth with an arbitrary username
+			username := cred.Username
+			if username == "" {
+				username = "x-access-token"
 			}
-			f, err := wt.Filesystem.Open(wt.Filesystem.Join(src.Path, file.Name()))
+			return &githttp.BasicAuth{
+				Username: username,
+				Password: cred.Password,
+			}, nil
+		case domain.CredentialTypeGitHubApp:
+			token, err := g.appCache.GetToken(ctx, cred)
 			if err != nil {
 				return nil, err
 			}
+			return &githttp.BasicAuth{
+				Username: "x-access-token",
+				Password: token,
+			}, nil
+		default:
+			return nil, fmt.Errorf("unknown credential type '%s'", cred.Type)
+		}
+	}
 
-			jobData, err := io.ReadAll(f)
-			if err != nil {
-				return nil, err
-			}
+	if src.DeployKeyID == "" {
+		return nil, nil
+	}
 
-			j, err := g.parser.ParseJob(ctx, string(jobData))
-			if err != nil {
-				g.logger.LogError(ctx, "Could not parse JobFile:%v - %v", file.Name(), err)
-				return nil, err
-			}
-			j.GitInfo = gitInfo
-			desiredState.Jobs[*j.Name] = j
-		}
-	} else {
-		f, err := wt.Filesystem.Open(src.Path)
-		if err != nil {
-			g.logger.LogError(ctx, " wt.Filesystem.Open(*src.Path) failed:%v", err)
-			return nil, err
-		}
+	key, err := g.keyRepo.GetKey(ctx, src.DeployKeyID)
+	if err != nil {
+		g.logger.LogError(ctx, "Could not GetKey:%v", err)
+		return nil, err
+	}
+
+	publicKeys, err := ssh.NewPublicKeys("git", []byte(key.Value), "")
+	if err != nil {
+		g.logger.LogError(ctx, "Could not NewPublicKeys:%v", err)
+		return nil, err
+	}
+
+	return g.setHostKeyCallback(ctx, src.URL, publicKeys)
+}
 
-		jobData, err := io.ReadAll(f)
+func (g *GitProvider) FetchDesiredState(ctx context.Context, src *domain.Source) (*application.DesiredState, error) {
+	auth, err := g.getAuth(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	cr, err := g.getRepo(ctx, src)
+	if err != nil {
+		g.logger.LogError(ctx, "Could not get repo:%s - %v", src.URL, err)
+		return nil, err
+	}
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	c, ref, err := g.resolveRevision(ctx, cr, src, auth)
+	if err != nil {
+		return nil, err
+	}
+	gitInfo := application.GitInfo{
+		GitCommit: c.Hash.String(),
+		GitRef:    ref,
+	}
+	g.logger.LogTrace(ctx, "Getting last commit...%v", gitInfo.GitCommit)
+
+	tree, err := c.Tree()
+	if err != nil {
+		g.logger.LogError(ctx, "commit.Tree failed:%v", err)
+		return nil, err
+	}
+
+	desiredState := &application.DesiredState{
+		GitInfo: gitInfo,
+		Jobs:    map[string]*application.JobInfo{},
+	}
+
+	var jobs map[string]string
+	parseOpts := application.ParseJobOptions{}
+	switch src.Type {
+	case "", domain.SourceTypeJobs:
+		parseOpts.Variables, err = g.loadVariables(ctx, tree, src)
 		if err != nil {
-			g.logger.LogError(ctx, " wt.Filesystem.Open(*src.Path).ReadAll failed:%v", err)
 			return nil, err
 		}
+		jobs, err = g.readJobFiles(ctx, tree, src)
+	case domain.SourceTypePack:
+		jobs, err = g.renderPack(ctx, tree, src)
+	default:
+		err = fmt.Errorf("unknown source type '%s'", src.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		j, err := g.parser.ParseJob(ctx, string(jobData))
+	files := make([]string, 0, len(jobs))
+	for file := range jobs {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	jobFiles := map[string]string{}
+	for _, file := range files {
+		parseOpts.Format = jobFormat(strings.TrimSuffix(file, ".tpl"))
+		j, err := g.parser.ParseJob(ctx, jobs[file], parseOpts)
 		if err != nil {
-			g.logger.LogError(ctx, "Could not parse JobFile:%v - %v", src.Path, err)
+			g.logger.LogError(ctx, "Could not parse JobFile:%v - %v", file, err)
 			return nil, err
 		}
+		if other, ok := jobFiles[*j.Name]; ok {
+			return nil, fmt.Errorf("job %s is defined in %s and %s", *j.Name, other, file)
+		}
+		jobFiles[*j.Name] = file
 		j.GitInfo = gitInfo
 		desiredState.Jobs[*j.Name] = j
 	}
@@ -0,0 +1,452 @@
+package github
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/hashicorp/nomad/api"
+
+	"github.com/nomad-ops/nomad-ops/backend/application"
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/interfaces/jobrender"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+// testParser uses the content of the job file as the job name
+type testParser struct{}
+
+func (testParser) ParseJob(ctx context.Context, j string, opts application.ParseJobOptions) (*application.JobInfo, error) {
+	name := j
+	return &application.JobInfo{
+		Job: &api.Job{
+			Name: &name,
+		},
+	}, nil
+}
+
+// commitFiles writes the given files into the repo at dir and commits them
+func commitFiles(t *testing.T, dir string, files map[string]string) string {
+	repo, err := git.PlainOpen(dir)
+	if err == git.ErrRepositoryNotExists {
+		repo, err = git.PlainInit(dir, false)
+	}
+	if err != nil {
+		t.Fatalf("Could not open repo:%v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Could not get worktree:%v", err)
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			t.Fatalf("Could not create dir:%v", err)
+		}
+		err = os.WriteFile(p, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Could not write file:%v", err)
+		}
+		_, err = wt.Add(name)
+		if err != nil {
+			t.Fatalf("Could not add file:%v", err)
+		}
+	}
+	hash, err := wt.Commit("test", &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  "test",
+			Email: "test@nomad-ops.org",
+			When:  time.Now(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not commit:%v", err)
+	}
+	return hash.String()
+}
+
+func TestFetchDesiredState(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewSimpleLogger(false, "Test")
+
+	remoteDir := t.TempDir()
+	reposDir := t.TempDir()
+
+	commit := commitFiles(t, remoteDir, map[string]string{
+		"jobs/a.nomad": "a",
+		"jobs/b.hcl":   "b",
+		"jobs/c.txt":   "c",
+	})
+
+	g, err := CreateGitProvider(ctx, logger, GitProviderConfig{
+		ReposDir: reposDir,
+		Persist:  true,
+		// not used for file urls
+		HostKeyPolicy: HostKeyPolicyStrict,
+	}, testParser{}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateGitProvider:%v", err)
+	}
+
+	src := &domain.Source{
+		ID:     "test",
+		URL:    "file://" + remoteDir,
+		Branch: "master",
+		Path:   "/jobs/",
+	}
+	desiredState, err := g.FetchDesiredState(ctx, src)
+	if err != nil {
+		t.Fatalf("Could not FetchDesiredState:%v", err)
+	}
+	if desiredState.GitInfo.GitCommit != commit {
+		t.Fatalf("Expected commit %s, got %s", commit, desiredState.GitInfo.GitCommit)
+	}
+	if len(desiredState.Jobs) != 2 || desiredState.Jobs["a"] == nil || desiredState.Jobs["b"] == nil {
+		t.Fatalf("Expected jobs a and b, got %v", desiredState.Jobs)
+	}
+
+	commit = commitFiles(t, remoteDir, map[string]string{
+		"jobs/d.hcl": "d",
+	})
+
+	// a new provider reuses the repo on disk
+	g, err = CreateGitProvider(ctx, logger, GitProviderConfig{
+		ReposDir: reposDir,
+		Persist:  true,
+		// not used for file urls
+		HostKeyPolicy: HostKeyPolicyStrict,
+	}, testParser{}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateGitProvider:%v", err)
+	}
+	src.Path = "jobs/d.hcl"
+	desiredState, err = g.FetchDesiredState(ctx, src)
+	if err != nil {
+		t.Fatalf("Could not FetchDesiredState:%v", err)
+	}
+	if desiredState.GitInfo.GitCommit != commit {
+		t.Fatalf("Expected commit %s, got %s", commit, desiredState.GitInfo.GitCommit)
+	}
+	if len(desiredState.Jobs) != 1 || desiredState.Jobs["d"] == nil {
+		t.Fatalf("Expected job d, got %v", desiredState.Jobs)
+	}
+
+	err = g.ReleaseSource(ctx, src.ID)
+	if err != nil {
+		t.Fatalf("Could not ReleaseSource:%v", err)
+	}
+	entries, err := os.ReadDir(reposDir)
+	if err != nil {
+		t.Fatalf("Could not read repos dir:%v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Expected the repo to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestPruneRepos(t *testing.T) {
+	ctx := context.Background()
+	reposDir := t.TempDir()
+
+	g, err := CreateGitProvider(ctx, log.NewSimpleLogger(false, "Test"), GitProviderConfig{
+		ReposDir:      reposDir,
+		Persist:       true,
+		HostKeyPolicy: HostKeyPolicyStrict,
+	}, testParser{}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateGitProvider:%v", err)
+	}
+
+	used := &domain.Source{ID: "used", URL: "https://example.com/used.git"}
+	unused := filepath.Base(g.repoDir("https://example.com/unused.git"))
+	for _, dir := range []string{filepath.Base(g.repoDir(used.URL)), unused, "lost+found", "data"} {
+		err := os.Mkdir(filepath.Join(reposDir, dir), 0700)
+		if err != nil {
+			t.Fatalf("Could not create %s:%v", dir, err)
+		}
+	}
+
+	err = g.PruneRepos(ctx, []*domain.Source{used})
+	if err != nil {
+		t.Fatalf("Could not PruneRepos:%v", err)
+	}
+	entries, err := os.ReadDir(reposDir)
+	if err != nil {
+		t.Fatalf("Could not read repos dir:%v", err)
+	}
+	remaining := map[string]bool{}
+	for _, e := range entries {
+		remaining[e.Name()] = true
+	}
+	if len(remaining) != 3 || remaining[unused] || !remaining["lost+found"] || !remaining["data"] {
+		t.Fatalf("Expected only the unused repo to be removed, got %v", remaining)
+	}
+}
+
+func TestFetchDesiredStateRevisions(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewSimpleLogger(false, "Test")
+
+	remoteDir := t.TempDir()
+
+	tag := func(name, commit string) {
+		repo, err := git.PlainOpen(remoteDir)
+		if err != nil {
+			t.Fatalf("Could not open repo:%v", err)
+		}
+		_, err = repo.CreateTag(name, plumbing.NewHash(commit), &git.CreateTagOptions{
+			Tagger: &object.Signature{
+				Name:  "test",
+				Email: "test@nomad-ops.org",
+				When:  time.Now(),
+			},
+			Message: name,
+		})
+		if err != nil {
+			t.Fatalf("Could not create tag:%v", err)
+		}
+	}
+
+	first := commitFiles(t, remoteDir, map[string]string{"job.nomad": "a"})
+	tag("v1.4.0", first)
+	second := commitFiles(t, remoteDir, map[string]string{"job.nomad": "b"})
+	tag("v1.4.2", second)
+	third := commitFiles(t, remoteDir, map[string]string{"job.nomad": "c"})
+	tag("v1.5.0", third)
+	commitFiles(t, remoteDir, map[string]string{"job.nomad": "d"})
+
+	g, err := CreateGitProvider(ctx, logger, GitProviderConfig{
+		HostKeyPolicy: HostKeyPolicyStrict,
+	}, testParser{}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateGitProvider:%v", err)
+	}
+
+	for _, tc := range []struct {
+		kind     domain.RevisionKind
+		revision string
+		commit   string
+		ref      string
+		job      string
+	}{
+		{kind: domain.RevisionKindTag, revision: "v1.4.0", commit: first, ref: "refs/tags/v1.4.0", job: "a"},
+		{kind: domain.RevisionKindSemver, revision: "v1.4.*", commit: second, ref: "refs/tags/v1.4.2", job: "b"},
+		{kind: domain.RevisionKindSemver, revision: "^1.4.0", commit: third, ref: "refs/tags/v1.5.0", job: "c"},
+		{kind: domain.RevisionKindCommit, revision: first, commit: first, ref: first, job: "a"},
+		{kind: domain.RevisionKindBranch, revision: "master", ref: "refs/heads/master", job: "d"},
+	} {
+		desiredState, err := g.FetchDesiredState(ctx, &domain.Source{
+			ID:           "test",
+			URL:          "file://" + remoteDir,
+			Branch:       tc.revision,
+			RevisionKind: tc.kind,
+			Path:         "job.nomad",
+		})
+		if err != nil {
+			t.Fatalf("%s %s: could not FetchDesiredState:%v", tc.kind, tc.revision, err)
+		}
+		if tc.commit != "" && desiredState.GitInfo.GitCommit != tc.commit {
+			t.Errorf("%s %s: expected commit %s, got %s", tc.kind, tc.revision, tc.commit, desiredState.GitInfo.GitCommit)
+		}
+		if desiredState.GitInfo.GitRef != tc.ref {
+			t.Errorf("%s %s: expected ref %s, got %s", tc.kind, tc.revision, tc.ref, desiredState.GitInfo.GitRef)
+		}
+		if desiredState.Jobs[tc.job] == nil {
+			t.Errorf("%s %s: expected job %s, got %v", tc.kind, tc.revision, tc.job, desiredState.Jobs)
+		}
+	}
+
+	// a cached commit is only returned if the remote can still be read
+	err = os.RemoveAll(remoteDir)
+	if err != nil {
+		t.Fatalf("Could not remove remote:%v", err)
+	}
+	_, err = g.FetchDesiredState(ctx, &domain.Source{
+		ID:           "test",
+		URL:          "file://" + remoteDir,
+		Branch:       first,
+		RevisionKind: domain.RevisionKindCommit,
+		Path:         "job.nomad",
+	})
+	if err == nil {
+		t.Errorf("Expected the cached commit not to be returned without access to the remote")
+	}
+}
+
+// variablesParser parses the variables like nomad, which rejects variables set twice,
+// uses the content of the job file and the image variable as the job name and records the variables
+type variablesParser struct {
+	variables []string
+}
+
+func (p *variablesParser) ParseJob(ctx context.Context, j string, opts application.ParseJobOptions) (*application.JobInfo, error) {
+	p.variables = append(p.variables, opts.Variables)
+	vars, err := jobrender.ParseVariablesFile("variables", opts.Variables)
+	if err != nil {
+		return nil, err
+	}
+	image, err := strconv.Unquote(vars["image"])
+	if err != nil {
+		return nil, err
+	}
+	return testParser{}.ParseJob(ctx, j+"-"+image, opts)
+}
+
+func TestFetchDesiredStateVariables(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewSimpleLogger(false, "Test")
+
+	remoteDir := t.TempDir()
+	commitFiles(t, remoteDir, map[string]string{
+		"jobs/a.nomad":   "a",
+		"jobs/prod.hcl":  "count = 3\nimage = \"nginx:prod\"\n",
+		"vars/image.hcl": "image = \"nginx\"\nports = [\n  80,\n  443,\n]\n",
+	})
+
+	parser := &variablesParser{}
+	g, err := CreateGitProvider(ctx, logger, GitProviderConfig{
+		HostKeyPolicy: HostKeyPolicyStrict,
+	}, parser, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateGitProvider:%v", err)
+	}
+
+	desiredState, err := g.FetchDesiredState(ctx, &domain.Source{
+		ID:       "test",
+		URL:      "file://" + remoteDir,
+		Branch:   "master",
+		Path:     "jobs",
+		VarFiles: []string{"/vars/image.hcl", "jobs/prod.hcl"},
+		Variables: map[string]string{
+			"count": "5",
+			"env":   "prod",
+			"quote": "say \"${hello}\"",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not FetchDesiredState:%v", err)
+	}
+	// later var files override earlier ones
+	if len(desiredState.Jobs) != 1 || desiredState.Jobs["a-nginx:prod"] == nil {
+		t.Fatalf("Expected only job a-nginx:prod, got %v", desiredState.Jobs)
+	}
+	// variables override the var files, every variable is set once
+	expected := "count = \"5\"\nenv = \"prod\"\nimage = \"nginx:prod\"\nports = [\n  80,\n  443,\n]\nquote = \"say \\\"$${hello}\\\"\"\n"
+	if len(parser.variables) != 1 || parser.variables[0] != expected {
+		t.Fatalf("Expected variables %q, got %q", expected, parser.variables)
+	}
+
+	desiredState, err = g.FetchDesiredState(ctx, &domain.Source{
+		ID:        "test",
+		URL:       "file://" + remoteDir,
+		Branch:    "master",
+		Path:      "jobs",
+		VarFiles:  []string{"vars/image.hcl"},
+		Variables: map[string]string{"image": "nginx:override"},
+	})
+	if err != nil {
+		t.Fatalf("Could not FetchDesiredState:%v", err)
+	}
+	if desiredState.Jobs["a-nginx:override"] == nil {
+		t.Fatalf("Expected the variable to override the var file, got %v", desiredState.Jobs)
+	}
+
+	_, err = g.FetchDesiredState(ctx, &domain.Source{
+		ID:       "test",
+		URL:      "file://" + remoteDir,
+		Branch:   "master",
+		Path:     "jobs",
+		VarFiles: []string{"vars/missing.hcl"},
+	})
+	if err == nil {
+		t.Fatalf("Expected an error for a missing var file")
+	}
+}
+
+func TestFetchDesiredStatePack(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewSimpleLogger(false, "Test")
+
+	remoteDir := t.TempDir()
+	commitFiles(t, remoteDir, map[string]string{
+		"packs/web/metadata.hcl":            `pack { name = "ignored" }`,
+		"packs/web/variables.hcl":           `variable "name" { default = "a" }`,
+		"packs/web/README.md":               "[[ not a template",
+		"packs/web/templates/job.nomad.tpl": `[[ .web.name ]]`,
+		"values/prod.hcl":                   `web.name = "b"`,
+	})
+
+	g, err := CreateGitProvider(ctx, logger, GitProviderConfig{
+		HostKeyPolicy: HostKeyPolicyStrict,
+	}, testParser{}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateGitProvider:%v", err)
+	}
+
+	src := &domain.Source{
+		ID:     "test",
+		URL:    "file://" + remoteDir,
+		Branch: "master",
+		Path:   "packs/web",
+		Type:   domain.SourceTypePack,
+	}
+	desiredState, err := g.FetchDesiredState(ctx, src)
+	if err != nil {
+		t.Fatalf("Could not FetchDesiredState:%v", err)
+	}
+	if len(desiredState.Jobs) != 1 || desiredState.Jobs["a"] == nil {
+		t.Fatalf("Expected job a, got %v", desiredState.Jobs)
+	}
+
+	src.VarFiles = []string{"values/prod.hcl"}
+	desiredState, err = g.FetchDesiredState(ctx, src)
+	if err != nil {
+		t.Fatalf("Could not FetchDesiredState:%v", err)
+	}
+	if len(desiredState.Jobs) != 1 || desiredState.Jobs["b"] == nil {
+		t.Fatalf("Expected job b, got %v", desiredState.Jobs)
+	}
+}
+
+func TestFetchDesiredStateTemplate(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewSimpleLogger(false, "Test")
+
+	remoteDir := t.TempDir()
+	commitFiles(t, remoteDir, map[string]string{
+		"jobs/a.nomad":     "[[ .prefix ]]-[[ .name ]]",
+		"jobs/values.json": `{"prefix": "dev", "name": "a"}`,
+	})
+
+	g, err := CreateGitProvider(ctx, logger, GitProviderConfig{
+		HostKeyPolicy: HostKeyPolicyStrict,
+	}, testParser{}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Could not CreateGitProvider:%v", err)
+	}
+
+	desiredState, err := g.FetchDesiredState(ctx, &domain.Source{
+		ID:          "test",
+		URL:         "file://" + remoteDir,
+		Branch:      "master",
+		Path:        "jobs",
+		Template:    true,
+		ValuesFiles: []string{"jobs/values.json"},
+		Values: map[string]interface{}{
+			"prefix": "prod",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not FetchDesiredState:%v", err)
+	}
+	if len(desiredState.Jobs) != 1 || desiredState.Jobs["prod-a"] == nil {
+		t.Fatalf("Expected job prod-a, got %v", desiredState.Jobs)
+	}
+}
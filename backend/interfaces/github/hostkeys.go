@@ -0,0 +1,165 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/skeema/knownhosts"
+	sshstd "golang.org/x/crypto/ssh"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+)
+
+type HostKeyPolicy string
+
+const (
+	// HostKeyPolicyStrict only accepts host keys found in the known hosts
+	HostKeyPolicyStrict HostKeyPolicy = "strict"
+	// HostKeyPolicyTOFU records the host key of unknown hosts on first use, changed keys are still rejected
+	HostKeyPolicyTOFU HostKeyPolicy = "tofu"
+	// HostKeyPolicyInsecure accepts every host key
+	HostKeyPolicyInsecure HostKeyPolicy = "insecure"
+)
+
+// defaultHostKeyAlgorithms are offered for hosts without known keys,
+// otherwise go-git falls back to ~/.ssh/known_hosts to determine them
+var defaultHostKeyAlgorithms = []string{
+	sshstd.KeyAlgoED25519,
+	sshstd.KeyAlgoECDSA256,
+	sshstd.KeyAlgoECDSA384,
+	sshstd.KeyAlgoECDSA521,
+	sshstd.KeyAlgoRSASHA512,
+	sshstd.KeyAlgoRSASHA256,
+	sshstd.KeyAlgoRSA,
+}
+
+// hostKeyAuth sets the host key algorithms matching the known keys of the host,
+// otherwise the server might present a key type we do not know
+type hostKeyAuth struct {
+	*ssh.PublicKeys
+	algorithms []string
+}
+
+func (a *hostKeyAuth) ClientConfig() (*sshstd.ClientConfig, error) {
+	cfg, err := a.PublicKeys.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.HostKeyAlgorithms = a.algorithms
+	return cfg, nil
+}
+
+// loadKnownHosts merges the configured known_hosts file with the stored known hosts
+func (g *GitProvider) loadKnownHosts(ctx context.Context) (*knownhosts.HostKeyDB, error) {
+	var lines []string
+	if g.hostRepo != nil {
+		hosts, err := g.hostRepo.ListKnownHosts(ctx)
+		if err != nil {
+			g.logger.LogError(ctx, "Could not ListKnownHosts:%v", err)
+			return nil, err
+		}
+		for _, h := range hosts {
+			key, _, _, _, err := sshstd.ParseAuthorizedKey([]byte(h.Key))
+			if err != nil {
+				g.logger.LogError(ctx, "Ignoring invalid known host key of %s:%v", h.Host, err)
+				continue
+			}
+			lines = append(lines, knownhosts.Line([]string{knownhosts.Normalize(h.Host)}, key))
+		}
+	}
+
+	// knownhosts can only read files
+	f, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	err = f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{f.Name()}
+	if g.cfg.KnownHostsFile != "" {
+		files = append(files, g.cfg.KnownHostsFile)
+	}
+	return knownhosts.NewDB(files...)
+}
+
+// hostKeyCallback verifies host keys according to the configured policy
+func (g *GitProvider) hostKeyCallback(ctx context.Context, db *knownhosts.HostKeyDB) sshstd.HostKeyCallback {
+	cb := db.HostKeyCallback()
+	return func(hostname string, remote net.Addr, key sshstd.PublicKey) error {
+		err := cb(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		fingerprint := sshstd.FingerprintSHA256(key)
+		if knownhosts.IsHostKeyChanged(err) {
+			return fmt.Errorf("host key of %s changed to %s %s, this could be a man-in-the-middle attack. Update the known hosts if the change is expected",
+				hostname, key.Type(), fingerprint)
+		}
+		if !knownhosts.IsHostUnknown(err) {
+			return err
+		}
+		if g.cfg.HostKeyPolicy != HostKeyPolicyTOFU {
+			return fmt.Errorf("host %s is unknown, add its key %s %s to the known hosts",
+				hostname, key.Type(), fingerprint)
+		}
+
+		g.logger.LogInfo(ctx, "Trusting host key %s %s of %s on first use", key.Type(), fingerprint, hostname)
+		if g.hostRepo == nil {
+			return nil
+		}
+		err = g.hostRepo.SaveKnownHost(ctx, &domain.KnownHost{
+			Host:        knownhosts.Normalize(hostname),
+			Key:         strings.TrimSpace(string(sshstd.MarshalAuthorizedKey(key))),
+			Fingerprint: fingerprint,
+		})
+		if err != nil {
+			g.logger.LogError(ctx, "Could not SaveKnownHost %s:%v", hostname, err)
+		}
+		return nil
+	}
+}
+
+// setHostKeyCallback applies the host key policy to the ssh auth of the given url
+func (g *GitProvider) setHostKeyCallback(ctx context.Context, url string, publicKeys *ssh.PublicKeys) (transport.AuthMethod, error) {
+	if g.cfg.HostKeyPolicy == HostKeyPolicyInsecure {
+		publicKeys.HostKeyCallback = sshstd.InsecureIgnoreHostKey()
+		return publicKeys, nil
+	}
+
+	db, err := g.loadKnownHosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	publicKeys.HostKeyCallback = g.hostKeyCallback(ctx, db)
+
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	port := ep.Port
+	if port == 0 {
+		port = 22
+	}
+	algorithms := db.HostKeyAlgorithms(fmt.Sprintf("%s:%d", ep.Host, port))
+	if len(algorithms) == 0 {
+		algorithms = defaultHostKeyAlgorithms
+	}
+	return &hostKeyAuth{
+		PublicKeys: publicKeys,
+		algorithms: algorithms,
+	}, nil
+}
@@ -0,0 +1,128 @@
+package github
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skeema/knownhosts"
+	sshstd "golang.org/x/crypto/ssh"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+type fakeKnownHostRepo struct {
+	hosts []*domain.KnownHost
+}
+
+func (r *fakeKnownHostRepo) ListKnownHosts(ctx context.Context) ([]*domain.KnownHost, error) {
+	return r.hosts, nil
+}
+
+func (r *fakeKnownHostRepo) SaveKnownHost(ctx context.Context, h *domain.KnownHost) error {
+	r.hosts = append(r.hosts, h)
+	return nil
+}
+
+func testHostKey(t *testing.T) sshstd.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key:%v", err)
+	}
+	key, err := sshstd.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Could not create public key:%v", err)
+	}
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewSimpleLogger(false, "Test")
+
+	knownKey := testHostKey(t)
+	otherKey := testHostKey(t)
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	err := os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{"known.example.com"}, knownKey)+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Could not write known hosts:%v", err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	verify := func(g *GitProvider, hostname string, key sshstd.PublicKey) error {
+		db, err := g.loadKnownHosts(ctx)
+		if err != nil {
+			t.Fatalf("Could not loadKnownHosts:%v", err)
+		}
+		return g.hostKeyCallback(ctx, db)(hostname, remote, key)
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		hostRepo := &fakeKnownHostRepo{}
+		g, err := CreateGitProvider(ctx, logger, GitProviderConfig{
+			KnownHostsFile: knownHostsFile,
+			HostKeyPolicy:  HostKeyPolicyStrict,
+		}, testParser{}, nil, nil, hostRepo)
+		if err != nil {
+			t.Fatalf("Could not CreateGitProvider:%v", err)
+		}
+
+		if err := verify(g, "known.example.com:22", knownKey); err != nil {
+			t.Fatalf("Expected the known key to be accepted:%v", err)
+		}
+		err = verify(g, "unknown.example.com:22", otherKey)
+		if err == nil || !strings.Contains(err.Error(), "is unknown") {
+			t.Fatalf("Expected the unknown host to be rejected, got %v", err)
+		}
+		if len(hostRepo.hosts) != 0 {
+			t.Fatalf("Expected no known host to be stored, got %d", len(hostRepo.hosts))
+		}
+	})
+
+	t.Run("tofu", func(t *testing.T) {
+		hostRepo := &fakeKnownHostRepo{}
+		g, err := CreateGitProvider(ctx, logger, GitProviderConfig{
+			KnownHostsFile: knownHostsFile,
+			HostKeyPolicy:  HostKeyPolicyTOFU,
+		}, testParser{}, nil, nil, hostRepo)
+		if err != nil {
+			t.Fatalf("Could not CreateGitProvider:%v", err)
+		}
+
+		// first use
+		if err := verify(g, "new.example.com:22", otherKey); err != nil {
+			t.Fatalf("Expected the unknown host to be trusted:%v", err)
+		}
+		if len(hostRepo.hosts) != 1 {
+			t.Fatalf("Expected the host key to be stored, got %d", len(hostRepo.hosts))
+		}
+		h := hostRepo.hosts[0]
+		if h.Host != "new.example.com" || h.Fingerprint != sshstd.FingerprintSHA256(otherKey) {
+			t.Fatalf("Unexpected known host: %+v", h)
+		}
+
+		// the stored key is used on the next connection
+		if err := verify(g, "new.example.com:22", otherKey); err != nil {
+			t.Fatalf("Expected the stored key to be accepted:%v", err)
+		}
+		err = verify(g, "new.example.com:22", knownKey)
+		if err == nil || !strings.Contains(err.Error(), "changed") {
+			t.Fatalf("Expected the changed key to be rejected, got %v", err)
+		}
+
+		// mismatch with the known hosts file
+		err = verify(g, "known.example.com:22", otherKey)
+		if err == nil || !strings.Contains(err.Error(), "changed") {
+			t.Fatalf("Expected the mismatched key to be rejected, got %v", err)
+		}
+		if len(hostRepo.hosts) != 1 {
+			t.Fatalf("Expected rejected keys not to be stored, got %d", len(hostRepo.hosts))
+		}
+	})
+}
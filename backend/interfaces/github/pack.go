@@ -0,0 +1,61 @@
+package github
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/interfaces/jobrender"
+)
+
+// renderPack renders the nomad pack at the path of the source with its var files and variables as values.
+// Returns the rendered jobs by the path of their template
+func (g *GitProvider) renderPack(ctx context.Context, tree *object.Tree, src *domain.Source) (map[string]string, error) {
+	packPath := repoFilePath(src.Path)
+	files, err := walkFiles(tree, packPath)
+	if err != nil {
+		g.logger.LogError(ctx, "Could not find pack %s:%v", src.Path, err)
+		return nil, err
+	}
+
+	opts := jobrender.PackOptions{
+		Files:     map[string]string{},
+		Variables: src.Variables,
+	}
+	if packPath != "" {
+		opts.Name = path.Base(packPath)
+	}
+	for _, file := range files {
+		rel := strings.TrimPrefix(strings.TrimPrefix(file, packPath), "/")
+		if rel != "metadata.hcl" && rel != "variables.hcl" && path.Dir(rel) != "templates" {
+			continue
+		}
+		f, err := tree.File(file)
+		if err != nil {
+			return nil, err
+		}
+		opts.Files[rel], err = f.Contents()
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts.VarFiles, err = readFiles(ctx, tree, src.VarFiles)
+	if err != nil {
+		g.logger.LogError(ctx, "Could not read var files:%v", err)
+		return nil, err
+	}
+
+	rendered, err := jobrender.RenderPack(opts)
+	if err != nil {
+		g.logger.LogError(ctx, "Could not render pack %s:%v", src.Path, err)
+		return nil, err
+	}
+	jobs := map[string]string{}
+	for file, content := range rendered {
+		jobs[path.Join(packPath, file)] = content
+	}
+	return jobs, nil
+}
@@ -0,0 +1,193 @@
+package github
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// semverComparison compares a version against a canonical semver version
+type semverComparison struct {
+	op      string
+	version string
+}
+
+func (c semverComparison) matches(v string) bool {
+	cmp := semver.Compare(v, c.version)
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// semverConstraint matches versions that satisfy all of its comparisons
+type semverConstraint struct {
+	comparisons []semverComparison
+	// prereleases only match if the constraint mentions one
+	prerelease bool
+}
+
+var operatorSpaceRegex = regexp.MustCompile(`([<>=!~^]+)\s+`)
+
+// parseSemverConstraint parses constraints like "v1.4.*", "~1.4", "^1.2.3" or ">=1.2.0, <2.0.0".
+// All terms separated by commas or spaces have to match
+func parseSemverConstraint(constraint string) (*semverConstraint, error) {
+	c := &semverConstraint{}
+	terms := strings.FieldsFunc(operatorSpaceRegex.ReplaceAllString(constraint, "$1"), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty semver constraint")
+	}
+	for _, term := range terms {
+		op := ""
+		for _, o := range []string{">=", "<=", "!=", ">", "<", "=", "~", "^"} {
+			if strings.HasPrefix(term, o) {
+				op = o
+				break
+			}
+		}
+		comparisons, err := parseSemverTerm(op, strings.TrimPrefix(term, op))
+		if err != nil {
+			return nil, fmt.Errorf("invalid semver constraint '%s': %v", constraint, err)
+		}
+		c.comparisons = append(c.comparisons, comparisons...)
+	}
+	for _, cmp := range c.comparisons {
+		if semver.Prerelease(cmp.version) != "" {
+			c.prerelease = true
+		}
+	}
+	return c, nil
+}
+
+// parseSemverTerm turns a single term into comparisons, partial versions and
+// wildcards become ranges, e.g. "1.4.*" => ">=1.4.0 <1.5.0"
+func parseSemverTerm(op, v string) ([]semverComparison, error) {
+	v = strings.TrimPrefix(v, "v")
+	pre := ""
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		pre = v[i:]
+		v = v[:i]
+	}
+
+	var parts []int
+	for _, p := range strings.Split(v, ".") {
+		if p == "*" || p == "x" || p == "X" {
+			break
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version '%s'", v)
+		}
+		parts = append(parts, n)
+	}
+	if len(parts) > 3 || (pre != "" && len(parts) != 3) {
+		return nil, fmt.Errorf("invalid version '%s'", v)
+	}
+
+	if len(parts) == 0 {
+		if op == "" || op == "=" {
+			// matches everything
+			return nil, nil
+		}
+		return nil, fmt.Errorf("missing version after '%s'", op)
+	}
+
+	full := make([]int, 3)
+	copy(full, parts)
+	lower := fmt.Sprintf("v%d.%d.%d%s", full[0], full[1], full[2], pre)
+
+	// upper bound of a partial version or a caret/tilde range
+	upper := func(level int) string {
+		bound := make([]int, 3)
+		copy(bound, full[:level+1])
+		bound[level]++
+		return fmt.Sprintf("v%d.%d.%d", bound[0], bound[1], bound[2])
+	}
+
+	switch op {
+	case "~":
+		level := 1
+		if len(parts) == 1 {
+			level = 0
+		}
+		return []semverComparison{{op: ">=", version: lower}, {op: "<", version: upper(level)}}, nil
+	case "^":
+		// the first non zero part must not change
+		level := 0
+		for level < len(parts)-1 && full[level] == 0 {
+			level++
+		}
+		return []semverComparison{{op: ">=", version: lower}, {op: "<", version: upper(level)}}, nil
+	case "", "=":
+		if len(parts) < 3 {
+			return []semverComparison{{op: ">=", version: lower}, {op: "<", version: upper(len(parts) - 1)}}, nil
+		}
+		return []semverComparison{{op: "=", version: lower}}, nil
+	default:
+		return []semverComparison{{op: op, version: lower}}, nil
+	}
+}
+
+// tagVersion returns the canonical semver version of a tag, e.g. "1.4.2" => "v1.4.2"
+func tagVersion(tag string) (string, bool) {
+	v := tag
+	if !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	if !semver.IsValid(v) {
+		return "", false
+	}
+	return semver.Canonical(v), true
+}
+
+// Matches returns true if the version satisfies the constraint
+func (c *semverConstraint) Matches(version string) bool {
+	if semver.Prerelease(version) != "" && !c.prerelease {
+		return false
+	}
+	for _, cmp := range c.comparisons {
+		if !cmp.matches(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// newestMatchingTag returns the tag with the highest version that satisfies the constraint
+func newestMatchingTag(constraint string, tags []string) (string, error) {
+	c, err := parseSemverConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+	newest := ""
+	newestVersion := ""
+	for _, tag := range tags {
+		v, ok := tagVersion(tag)
+		if !ok || !c.Matches(v) {
+			continue
+		}
+		if newest == "" || semver.Compare(v, newestVersion) > 0 {
+			newest = tag
+			newestVersion = v
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no tag matches '%s'", constraint)
+	}
+	return newest, nil
+}
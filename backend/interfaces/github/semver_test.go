@@ -0,0 +1,45 @@
+package github
+
+import "testing"
+
+func TestNewestMatchingTag(t *testing.T) {
+	tags := []string{"v1.3.9", "v1.4.0", "v1.4.2", "v1.4.10", "v1.5.0-rc1", "v1.5.0", "2.0.0", "latest"}
+
+	for _, tc := range []struct {
+		constraint string
+		expected   string
+	}{
+		{constraint: "v1.4.*", expected: "v1.4.10"},
+		{constraint: "1.4.x", expected: "v1.4.10"},
+		{constraint: "~1.3", expected: "v1.3.9"},
+		{constraint: "^1.3.0", expected: "v1.5.0"},
+		{constraint: ">=1.2.0, <1.4.5", expected: "v1.4.2"},
+		{constraint: ">= 1.0.0 < 2", expected: "v1.5.0"},
+		{constraint: "v1.4.2", expected: "v1.4.2"},
+		{constraint: "*", expected: "2.0.0"},
+		{constraint: ">=1.5.0-rc0, <1.5.0", expected: "v1.5.0-rc1"},
+		{constraint: "v3.*", expected: ""},
+	} {
+		tag, err := newestMatchingTag(tc.constraint, tags)
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("%s: expected no match, got %s", tc.constraint, tag)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error:%v", tc.constraint, err)
+			continue
+		}
+		if tag != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.constraint, tc.expected, tag)
+		}
+	}
+
+	for _, constraint := range []string{"", "abc", ">=", "1.2.3.4"} {
+		_, err := parseSemverConstraint(constraint)
+		if err == nil {
+			t.Errorf("%s: expected an error", constraint)
+		}
+	}
+}
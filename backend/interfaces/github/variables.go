@@ -0,0 +1,76 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/interfaces/jobrender"
+)
+
+var variableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+var hclStringReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"${", "$${",
+	"%{", "%%{",
+)
+
+// repoFilePath turns a path of the source into a path in the repo tree
+func repoFilePath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+// loadVariables merges the var files of the source and its variables into the content of a HCL2 variables file.
+// A variable set more than once takes the last value, var files in order and the variables last.
+// Nomad converts the string values to the declared variable types
+func (g *GitProvider) loadVariables(ctx context.Context, tree *object.Tree, src *domain.Source) (string, error) {
+	values := map[string]string{}
+	for _, varFile := range src.VarFiles {
+		f, err := tree.File(repoFilePath(varFile))
+		if err != nil {
+			g.logger.LogError(ctx, "Could not find var file %s:%v", varFile, err)
+			return "", fmt.Errorf("could not find var file %s: %v", varFile, err)
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return "", err
+		}
+		fileValues, err := jobrender.ParseVariablesFile(varFile, content)
+		if err != nil {
+			g.logger.LogError(ctx, "Could not parse var file %s:%v", varFile, err)
+			return "", fmt.Errorf("could not parse var file %s: %v", varFile, err)
+		}
+		for name, v := range fileValues {
+			values[name] = v
+		}
+	}
+
+	for name, v := range src.Variables {
+		if !variableNameRegex.MatchString(name) {
+			return "", fmt.Errorf("invalid variable name '%s'", name)
+		}
+		values[name] = "\"" + hclStringReplacer.Replace(v) + "\""
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(fmt.Sprintf("%s = %s\n", name, values[name]))
+	}
+	return sb.String(), nil
+}
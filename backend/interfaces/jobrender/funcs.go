@@ -0,0 +1,140 @@
+package jobrender
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs returns the helpers available in job templates, named like their sprig counterparts
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"quote": func(v interface{}) string {
+			return strconv.Quote(toString(v))
+		},
+		"squote": func(v interface{}) string {
+			return "'" + toString(v) + "'"
+		},
+		"toJson": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"toPrettyJson": func(v interface{}) (string, error) {
+			b, err := json.MarshalIndent(v, "", "  ")
+			return string(b), err
+		},
+		"default": func(d interface{}, v ...interface{}) interface{} {
+			if len(v) == 0 || empty(v[0]) {
+				return d
+			}
+			return v[0]
+		},
+		"empty": empty,
+		"coalesce": func(v ...interface{}) interface{} {
+			for _, e := range v {
+				if !empty(e) {
+					return e
+				}
+			}
+			return nil
+		},
+		"upper": func(s interface{}) string {
+			return strings.ToUpper(toString(s))
+		},
+		"lower": func(s interface{}) string {
+			return strings.ToLower(toString(s))
+		},
+		"trim": func(s interface{}) string {
+			return strings.TrimSpace(toString(s))
+		},
+		"trimPrefix": func(prefix string, s interface{}) string {
+			return strings.TrimPrefix(toString(s), prefix)
+		},
+		"trimSuffix": func(suffix string, s interface{}) string {
+			return strings.TrimSuffix(toString(s), suffix)
+		},
+		"replace": func(old, new string, s interface{}) string {
+			return strings.ReplaceAll(toString(s), old, new)
+		},
+		"contains": func(substr string, s interface{}) bool {
+			return strings.Contains(toString(s), substr)
+		},
+		"hasPrefix": func(prefix string, s interface{}) bool {
+			return strings.HasPrefix(toString(s), prefix)
+		},
+		"hasSuffix": func(suffix string, s interface{}) bool {
+			return strings.HasSuffix(toString(s), suffix)
+		},
+		"join": func(sep string, v interface{}) string {
+			return strings.Join(toStringList(v), sep)
+		},
+		"splitList": func(sep string, s interface{}) []string {
+			return strings.Split(toString(s), sep)
+		},
+		"indent": func(n int, s interface{}) string {
+			return indent(n, toString(s))
+		},
+		"nindent": func(n int, s interface{}) string {
+			return "\n" + indent(n, toString(s))
+		},
+		"list": func(v ...interface{}) []interface{} {
+			return v
+		},
+		"dict": func(v ...interface{}) (map[string]interface{}, error) {
+			if len(v)%2 != 0 {
+				return nil, fmt.Errorf("dict expects an even number of arguments")
+			}
+			d := map[string]interface{}{}
+			for i := 0; i < len(v); i += 2 {
+				d[toString(v[i])] = v[i+1]
+			}
+			return d, nil
+		},
+	}
+}
+
+func toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func toStringList(v interface{}) []string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []string{toString(v)}
+	}
+	res := make([]string, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		res[i] = toString(rv.Index(i).Interface())
+	}
+	return res
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// empty returns true for nil and zero values as well as empty strings, slices and maps
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return rv.IsZero()
+	}
+}
@@ -0,0 +1,510 @@
+package jobrender
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// hclBody is a parsed HCL body with its attributes and nested blocks
+type hclBody struct {
+	Attrs map[string]interface{}
+	// source of the attribute expressions
+	Raw    map[string]string
+	Blocks []*hclBlock
+}
+
+type hclBlock struct {
+	Type   string
+	Labels []string
+	Body   *hclBody
+}
+
+// hclParser parses the subset of HCL used by variable declarations and values files.
+// Expressions have to be literals: strings, heredocs, numbers, bools, null, lists and objects,
+// anything else is an error. The type constraint of a variable block is kept as its source,
+// string templates are kept as they are
+type hclParser struct {
+	name string
+	src  []rune
+	pos  int
+	line int
+}
+
+// parseHCL parses the content of a HCL file, name is only used for error messages
+func parseHCL(name, src string) (*hclBody, error) {
+	p := &hclParser{
+		name: name,
+		src:  []rune(src),
+		line: 1,
+	}
+	return p.parseBody(0, "")
+}
+
+// ParseVariablesFile parses a HCL2 variables file and returns the source of the value of each variable
+func ParseVariablesFile(name, src string) (map[string]string, error) {
+	body, err := parseHCL(name, src)
+	if err != nil {
+		return nil, err
+	}
+	if len(body.Blocks) > 0 {
+		return nil, fmt.Errorf("%s: unexpected block %s, variables files only contain attributes", name, body.Blocks[0].Type)
+	}
+	return body.Raw, nil
+}
+
+// parseHCLValue parses a single literal expression, e.g. ["dc1", "dc2"] or 3
+func parseHCLValue(name, src string) (interface{}, error) {
+	p := &hclParser{
+		name: name,
+		src:  []rune(src),
+		line: 1,
+	}
+	v, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace(true)
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q after value", p.peek())
+	}
+	return v, nil
+}
+
+func (p *hclParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.name, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *hclParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *hclParser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *hclParser) peekAt(offset int) rune {
+	if p.pos+offset >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos+offset]
+}
+
+func (p *hclParser) next() rune {
+	r := p.src[p.pos]
+	p.pos++
+	if r == '\n' {
+		p.line++
+	}
+	return r
+}
+
+// skipSpace skips whitespace and comments, newlines only if newlines is true
+func (p *hclParser) skipSpace(newlines bool) {
+	for !p.eof() {
+		r := p.peek()
+		switch {
+		case r == '\n':
+			if !newlines {
+				return
+			}
+			p.next()
+		case unicode.IsSpace(r):
+			p.next()
+		case r == '#' || (r == '/' && p.peekAt(1) == '/'):
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		case r == '/' && p.peekAt(1) == '*':
+			p.next()
+			p.next()
+			for !p.eof() && !(p.peek() == '*' && p.peekAt(1) == '/') {
+				p.next()
+			}
+			if !p.eof() {
+				p.next()
+				p.next()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentChar(r rune) bool {
+	// dots allow traversals like pack.variable as attribute names of values files
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+func (p *hclParser) parseIdent() (string, error) {
+	if !isIdentStart(p.peek()) {
+		return "", p.errorf("expected identifier, got %q", p.peek())
+	}
+	start := p.pos
+	for !p.eof() && isIdentChar(p.peek()) {
+		p.next()
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+// parseBody parses attributes and blocks until the closing rune or the end of the file if closing is 0,
+// blockType is the type of the block the body belongs to
+func (p *hclParser) parseBody(closing rune, blockType string) (*hclBody, error) {
+	body := &hclBody{
+		Attrs: map[string]interface{}{},
+		Raw:   map[string]string{},
+	}
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			if closing != 0 {
+				return nil, p.errorf("missing '%c'", closing)
+			}
+			return body, nil
+		}
+		if closing != 0 && p.peek() == closing {
+			p.next()
+			return body, nil
+		}
+
+		name, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if p.peek() == '=' {
+			p.next()
+			p.skipSpace(true)
+			start := p.pos
+			var v interface{}
+			if blockType == "variable" && name == "type" {
+				v, err = p.parseTypeConstraint()
+			} else {
+				v, err = p.parseExpr()
+			}
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := body.Attrs[name]; ok {
+				return nil, p.errorf("attribute %s redefined", name)
+			}
+			body.Attrs[name] = v
+			body.Raw[name] = string(p.src[start:p.pos])
+			continue
+		}
+
+		block := &hclBlock{
+			Type: name,
+		}
+		for block.Body == nil {
+			p.skipSpace(false)
+			switch r := p.peek(); {
+			case r == '"':
+				label, err := p.parseString()
+				if err != nil {
+					return nil, err
+				}
+				block.Labels = append(block.Labels, label)
+			case r == '{':
+				p.next()
+				block.Body, err = p.parseBody('}', name)
+				if err != nil {
+					return nil, err
+				}
+			case isIdentStart(r):
+				label, err := p.parseIdent()
+				if err != nil {
+					return nil, err
+				}
+				block.Labels = append(block.Labels, label)
+			default:
+				return nil, p.errorf("expected '=' or block after %s", name)
+			}
+		}
+		body.Blocks = append(body.Blocks, block)
+	}
+}
+
+func (p *hclParser) parseExpr() (interface{}, error) {
+	p.skipSpace(true)
+	r := p.peek()
+	switch {
+	case r == '"':
+		return p.parseString()
+	case r == '<' && p.peekAt(1) == '<':
+		return p.parseHeredoc()
+	case r == '[':
+		return p.parseList()
+	case r == '{':
+		return p.parseObject()
+	case r == '-' || unicode.IsDigit(r):
+		return p.parseNumber()
+	case isIdentStart(r):
+		ident, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		switch ident {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return nil, p.errorf("unsupported expression %s, only literal values are allowed", ident)
+	default:
+		return nil, p.errorf("unexpected %q", r)
+	}
+}
+
+// parseTypeConstraint parses a type constraint like string or list(object({ name = string })) and returns its source
+func (p *hclParser) parseTypeConstraint() (string, error) {
+	start := p.pos
+	_, err := p.parseIdent()
+	if err != nil {
+		return "", err
+	}
+	p.skipSpace(false)
+	if p.peek() == '(' {
+		err = p.skipParens()
+		if err != nil {
+			return "", err
+		}
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+// skipParens skips a balanced expression in parentheses
+func (p *hclParser) skipParens() error {
+	depth := 0
+	for !p.eof() {
+		switch p.peek() {
+		case '"':
+			_, err := p.parseString()
+			if err != nil {
+				return err
+			}
+			continue
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		}
+		p.next()
+		if depth == 0 {
+			return nil
+		}
+	}
+	return p.errorf("missing ')'")
+}
+
+func (p *hclParser) parseString() (string, error) {
+	p.next() // opening quote
+	var sb strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		r := p.next()
+		switch r {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			e := p.next()
+			switch e {
+			case 'n':
+				sb.WriteRune('\n')
+			case 'r':
+				sb.WriteRune('\r')
+			case 't':
+				sb.WriteRune('\t')
+			case '"', '\\':
+				sb.WriteRune(e)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.src) {
+					return "", p.errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(string(p.src[p.pos:p.pos+n]), 16, 32)
+				if err != nil {
+					return "", p.errorf("invalid unicode escape")
+				}
+				p.pos += n
+				sb.WriteRune(rune(code))
+			default:
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+		case '$', '%':
+			// $${ and %%{ escape template sequences
+			if p.peek() == r && p.peekAt(1) == '{' {
+				p.next()
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+}
+
+func (p *hclParser) parseHeredoc() (string, error) {
+	p.next()
+	p.next()
+	indented := false
+	if p.peek() == '-' {
+		indented = true
+		p.next()
+	}
+	marker, err := p.parseIdent()
+	if err != nil {
+		return "", err
+	}
+	for !p.eof() && p.peek() != '\n' {
+		p.next()
+	}
+	if p.eof() {
+		return "", p.errorf("unterminated heredoc %s", marker)
+	}
+	p.next()
+
+	var lines []string
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated heredoc %s", marker)
+		}
+		start := p.pos
+		for !p.eof() && p.peek() != '\n' {
+			p.next()
+		}
+		line := string(p.src[start:p.pos])
+		if strings.TrimSpace(line) == marker {
+			break
+		}
+		lines = append(lines, line)
+		if !p.eof() {
+			p.next()
+		}
+	}
+
+	if indented {
+		// remove the common leading whitespace
+		prefix := -1
+		for _, l := range lines {
+			if strings.TrimSpace(l) == "" {
+				continue
+			}
+			n := len(l) - len(strings.TrimLeft(l, " \t"))
+			if prefix < 0 || n < prefix {
+				prefix = n
+			}
+		}
+		for i, l := range lines {
+			if len(l) >= prefix && prefix > 0 {
+				lines[i] = l[prefix:]
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func (p *hclParser) parseNumber() (float64, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.next()
+	}
+	for !p.eof() {
+		r := p.peek()
+		if !unicode.IsDigit(r) && r != '.' && r != 'e' && r != 'E' &&
+			!((r == '+' || r == '-') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+			break
+		}
+		p.next()
+	}
+	n, err := strconv.ParseFloat(string(p.src[start:p.pos]), 64)
+	if err != nil {
+		return 0, p.errorf("invalid number %s", string(p.src[start:p.pos]))
+	}
+	return n, nil
+}
+
+func (p *hclParser) parseList() ([]interface{}, error) {
+	p.next()
+	list := []interface{}{}
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return nil, p.errorf("missing ']'")
+		}
+		if p.peek() == ']' {
+			p.next()
+			return list, nil
+		}
+		v, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		p.skipSpace(true)
+		if p.peek() == ',' {
+			p.next()
+		} else if p.peek() != ']' {
+			return nil, p.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+func (p *hclParser) parseObject() (map[string]interface{}, error) {
+	p.next()
+	obj := map[string]interface{}{}
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return nil, p.errorf("missing '}'")
+		}
+		if p.peek() == '}' {
+			p.next()
+			return obj, nil
+		}
+		var key string
+		var err error
+		if p.peek() == '"' {
+			key, err = p.parseString()
+		} else {
+			key, err = p.parseIdent()
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if p.peek() != '=' && p.peek() != ':' {
+			return nil, p.errorf("expected '=' or ':' after %s", key)
+		}
+		p.next()
+		v, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = v
+		p.skipSpace(false)
+		if p.peek() == ',' {
+			p.next()
+		}
+	}
+}
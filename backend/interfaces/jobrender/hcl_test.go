@@ -0,0 +1,122 @@
+package jobrender
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHCL(t *testing.T) {
+	body, err := parseHCL("variables.hcl", `
+# comment
+variable "count" {
+  description = "number of instances" // comment
+  type        = number
+  default     = 2
+}
+
+variable "ports" {
+  type = list(object({
+    name = string
+    port = number
+  }))
+  default = [
+    { name = "http", port = 8080 },
+    { "name" : "metrics", "port" : 9090 },
+  ]
+}
+
+/* multi
+   line */
+variable "config" {
+  default = <<-EOT
+    a = "${b}"
+      c
+    EOT
+}
+
+image   = "nginx:\"1.27\"\t$${x}"
+enabled = true
+missing = null
+pack.replicas = -1.5e1
+`)
+	if err != nil {
+		t.Fatalf("Could not parse:%v", err)
+	}
+
+	expectedAttrs := map[string]interface{}{
+		"image":         "nginx:\"1.27\"\t${x}",
+		"enabled":       true,
+		"missing":       nil,
+		"pack.replicas": -15.0,
+	}
+	if !reflect.DeepEqual(body.Attrs, expectedAttrs) {
+		t.Errorf("Expected attributes %v, got %v", expectedAttrs, body.Attrs)
+	}
+
+	if len(body.Blocks) != 3 {
+		t.Fatalf("Expected 3 blocks, got %d", len(body.Blocks))
+	}
+	defaults := map[string]interface{}{}
+	for _, b := range body.Blocks {
+		if b.Type != "variable" || len(b.Labels) != 1 {
+			t.Fatalf("Unexpected block %s %v", b.Type, b.Labels)
+		}
+		defaults[b.Labels[0]] = b.Body.Attrs["default"]
+	}
+	expectedDefaults := map[string]interface{}{
+		"count": 2.0,
+		"ports": []interface{}{
+			map[string]interface{}{"name": "http", "port": 8080.0},
+			map[string]interface{}{"name": "metrics", "port": 9090.0},
+		},
+		"config": "a = \"${b}\"\n  c\n",
+	}
+	if !reflect.DeepEqual(defaults, expectedDefaults) {
+		t.Errorf("Expected defaults %v, got %v", expectedDefaults, defaults)
+	}
+	if body.Blocks[0].Body.Attrs["type"] != "number" || body.Blocks[1].Body.Attrs["type"] != "list(object({\n    name = string\n    port = number\n  }))" {
+		t.Errorf("Expected the type constraints to be kept, got %v and %v", body.Blocks[0].Body.Attrs["type"], body.Blocks[1].Body.Attrs["type"])
+	}
+
+	for _, invalid := range []string{
+		`a = "unterminated`,
+		`a = [1, 2`,
+		`variable "x" {`,
+		`a = 1
+a = 2`,
+		`a = upper("x")`,
+		`a = var.image`,
+		`type = string`,
+		`variable "x" {
+  default = list(string)
+}`,
+	} {
+		_, err := parseHCL("invalid.hcl", invalid)
+		if err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestParseVariablesFile(t *testing.T) {
+	values, err := ParseVariablesFile("prod.hcl", "image = \"nginx\" # comment\nports = [80, 443]\n")
+	if err != nil {
+		t.Fatalf("Could not parse:%v", err)
+	}
+	expected := map[string]string{
+		"image": `"nginx"`,
+		"ports": "[80, 443]",
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected values %v, got %v", expected, values)
+	}
+
+	_, err = ParseVariablesFile("prod.hcl", "image = \"a\"\nimage = \"b\"\n")
+	if err == nil {
+		t.Errorf("Expected an error for a variable set twice")
+	}
+	_, err = ParseVariablesFile("prod.hcl", "variable \"image\" {}\n")
+	if err == nil {
+		t.Errorf("Expected an error for a block")
+	}
+}
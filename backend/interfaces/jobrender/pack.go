@@ -0,0 +1,187 @@
+package jobrender
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// VarFile is a values file, its format is determined by the extension of its name (.json or .hcl)
+type VarFile struct {
+	Name    string
+	Content string
+}
+
+// PackOptions to render a nomad pack
+type PackOptions struct {
+	// defaults to the name in metadata.hcl
+	Name string
+	// pack files by their path relative to the pack root, e.g. templates/web.nomad.tpl
+	Files map[string]string
+	// applied in order, later files override earlier ones
+	VarFiles []VarFile
+	// override all var files. Values of variables that are not strings are parsed as HCL literals
+	Variables map[string]string
+}
+
+// RenderPack renders the job templates of a nomad pack.
+// Variables are declared with their defaults in variables.hcl and can be set by var files
+// as "name = value" or "pack.name = value". Templates use [[ ]] as delimiters and access
+// variables with .my.name, .<pack>.name or var "name" .
+// Returns the rendered jobs by the path of their template, templates rendering to nothing are skipped.
+// Dependencies and variable validations are not supported and fail the rendering
+func RenderPack(opts PackOptions) (map[string]string, error) {
+	name := opts.Name
+	if content, ok := opts.Files["metadata.hcl"]; ok {
+		metadata, err := parseHCL("metadata.hcl", content)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range metadata.Blocks {
+			switch b.Type {
+			case "pack":
+				if opts.Name == "" {
+					name = toString(b.Body.Attrs["name"])
+				}
+			case "dependency":
+				return nil, fmt.Errorf("metadata.hcl: dependency %s, pack dependencies are not supported", strings.Join(b.Labels, " "))
+			}
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("pack has no name")
+	}
+
+	declared := map[string]bool{}
+	types := map[string]string{}
+	values := map[string]interface{}{}
+	if content, ok := opts.Files["variables.hcl"]; ok {
+		variables, err := parseHCL("variables.hcl", content)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range variables.Blocks {
+			if b.Type != "variable" || len(b.Labels) != 1 {
+				continue
+			}
+			for _, nested := range b.Body.Blocks {
+				return nil, fmt.Errorf("variables.hcl: %s block of variable %s is not supported", nested.Type, b.Labels[0])
+			}
+			declared[b.Labels[0]] = true
+			types[b.Labels[0]] = toString(b.Body.Attrs["type"])
+			values[b.Labels[0]] = b.Body.Attrs["default"]
+		}
+	}
+
+	set := func(source, key string, v interface{}) error {
+		key = strings.TrimPrefix(key, name+".")
+		if !declared[key] {
+			return fmt.Errorf("%s: variable %s is not declared by pack %s", source, key, name)
+		}
+		values[key] = v
+		return nil
+	}
+	for _, f := range opts.VarFiles {
+		fileValues, err := readValuesFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileValues {
+			err := set(f.Name, k, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for k, raw := range opts.Variables {
+		key := strings.TrimPrefix(k, name+".")
+		var v interface{} = raw
+		if !isStringVariable(types[key], values[key]) {
+			var err error
+			v, err = parseHCLValue("variables."+k, raw)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := set("variables", k, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := map[string]interface{}{
+		name: values,
+		"my": values,
+		"nomad_pack": map[string]interface{}{
+			"pack": map[string]interface{}{
+				"name": name,
+			},
+		},
+	}
+	funcs := templateFuncs()
+	funcs["var"] = func(key string, _ interface{}) (interface{}, error) {
+		if !declared[key] {
+			return nil, fmt.Errorf("variable %s is not declared by pack %s", key, name)
+		}
+		return values[key], nil
+	}
+	funcs["meta"] = func(key string, _ interface{}) (string, error) {
+		if key != "pack.name" {
+			return "", fmt.Errorf("unknown meta key %s", key)
+		}
+		return name, nil
+	}
+
+	var templates []string
+	for f := range opts.Files {
+		if path.Dir(f) == "templates" && strings.HasSuffix(f, ".tpl") {
+			templates = append(templates, f)
+		}
+	}
+	sort.Strings(templates)
+
+	// fail on typos instead of rendering <no value>
+	root := template.New(name).Delims("[[", "]]").Funcs(funcs).Option("missingkey=error")
+	for _, f := range templates {
+		_, err := root.New(f).Parse(opts.Files[f])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rendered := map[string]string{}
+	for _, f := range templates {
+		base := path.Base(f)
+		// helpers only define templates
+		if strings.HasPrefix(base, "_") || !strings.Contains(base, ".nomad") {
+			continue
+		}
+		var buf bytes.Buffer
+		err := root.ExecuteTemplate(&buf, f, data)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(buf.String()) == "" {
+			continue
+		}
+		rendered[f] = buf.String()
+	}
+	return rendered, nil
+}
+
+// isStringVariable returns true if the variable is of type string,
+// variables without a type are strings unless their default is not
+func isStringVariable(typ string, def interface{}) bool {
+	switch typ {
+	case "string":
+		return true
+	case "", "any":
+		_, ok := def.(string)
+		return ok || def == nil
+	default:
+		return false
+	}
+}
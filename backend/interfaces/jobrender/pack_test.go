@@ -0,0 +1,115 @@
+package jobrender
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderPack(t *testing.T) {
+	files := map[string]string{
+		"metadata.hcl": `
+pack {
+  name = "web"
+}`,
+		"variables.hcl": `
+variable "job_name" {
+  default = ""
+}
+variable "count" {
+  type    = number
+  default = 1
+}
+variable "datacenters" {
+  type    = list(string)
+  default = ["dc1"]
+}
+variable "enabled" {
+  default = true
+}`,
+		"templates/_helpers.tpl": `[[- define "job_name" -]]
+[[- .my.job_name | default .nomad_pack.pack.name -]]
+[[- end -]]`,
+		"templates/web.nomad.tpl": `job [[ template "job_name" . ]] {
+  datacenters = [[ .web.datacenters | toJson ]]
+  count = [[ var "count" . ]]
+}`,
+		"templates/optional.nomad.tpl": `[[ if not .my.enabled ]]job "optional" {}[[ end ]]`,
+		"templates/notes.tpl":          "not a job",
+	}
+
+	rendered, err := RenderPack(PackOptions{
+		Files: files,
+		VarFiles: []VarFile{
+			{Name: "prod.hcl", Content: "web.count = 3\ndatacenters = [\"dc1\", \"dc2\"]\n"},
+			{Name: "prod.json", Content: `{"count": 4}`},
+		},
+		Variables: map[string]string{
+			"job_name": "web-prod",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not RenderPack:%v", err)
+	}
+	if len(rendered) != 1 {
+		t.Fatalf("Expected only the web job, got %v", rendered)
+	}
+	expected := `job web-prod {
+  datacenters = ["dc1","dc2"]
+  count = 4
+}`
+	if rendered["templates/web.nomad.tpl"] != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, rendered["templates/web.nomad.tpl"])
+	}
+
+	// .web is unknown for a pack named other
+	rendered, err = RenderPack(PackOptions{
+		Name:  "other",
+		Files: files,
+	})
+	if err == nil || !strings.Contains(err.Error(), "web") {
+		t.Fatalf("Expected an error for .web in pack other, got %v - %v", err, rendered)
+	}
+
+	// variables are typed by their declaration
+	rendered, err = RenderPack(PackOptions{
+		Files: files,
+		Variables: map[string]string{
+			"web.count":   "5",
+			"datacenters": `["dc3"]`,
+			"job_name":    "5",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not RenderPack:%v", err)
+	}
+	expected = `job 5 {
+  datacenters = ["dc3"]
+  count = 5
+}`
+	if rendered["templates/web.nomad.tpl"] != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, rendered["templates/web.nomad.tpl"])
+	}
+
+	for name, tc := range map[string]struct {
+		files     map[string]string
+		variables map[string]string
+	}{
+		"undeclared variable": {files: files, variables: map[string]string{"unknown": "x"}},
+		"invalid list":        {files: files, variables: map[string]string{"datacenters": "dc3"}},
+		"dependency": {files: map[string]string{
+			"metadata.hcl": "pack {\n  name = \"web\"\n}\ndependency \"redis\" {\n  source = \"git://example.com/packs/redis\"\n}\n",
+		}},
+		"validation": {files: map[string]string{
+			"metadata.hcl":  "pack {\n  name = \"web\"\n}\n",
+			"variables.hcl": "variable \"count\" {\n  validation {\n    error_message = \"too small\"\n  }\n}\n",
+		}},
+	} {
+		_, err = RenderPack(PackOptions{
+			Files:     tc.files,
+			Variables: tc.variables,
+		})
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
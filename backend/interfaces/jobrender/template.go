@@ -0,0 +1,127 @@
+package jobrender
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultLeftDelim  = "[["
+	defaultRightDelim = "]]"
+)
+
+// readValuesFile returns the top level values of a .json, .yaml or .hcl file
+func readValuesFile(f VarFile) (map[string]interface{}, error) {
+	switch strings.ToLower(path.Ext(f.Name)) {
+	case ".json":
+		values := map[string]interface{}{}
+		err := json.Unmarshal([]byte(f.Content), &values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		return values, nil
+	case ".yaml", ".yml":
+		values := map[string]interface{}{}
+		err := yaml.Unmarshal([]byte(f.Content), &values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		return values, nil
+	default:
+		body, err := parseHCL(f.Name, f.Content)
+		if err != nil {
+			return nil, err
+		}
+		return body.Attrs, nil
+	}
+}
+
+// LoadValues merges the top level values of the files in order and the given values on top
+func LoadValues(files []VarFile, values map[string]interface{}) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	for _, f := range files {
+		fileValues, err := readValuesFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileValues {
+			res[k] = v
+		}
+	}
+	for k, v := range values {
+		res[k] = v
+	}
+	return res, nil
+}
+
+// ParseDelims parses delimiters separated by a space like "{{ }}", empty means [[ ]]
+func ParseDelims(delims string) (string, string, error) {
+	if strings.TrimSpace(delims) == "" {
+		return defaultLeftDelim, defaultRightDelim, nil
+	}
+	parts := strings.Fields(delims)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid template delimiters '%s', expected e.g. '[[ ]]'", delims)
+	}
+	return parts[0], parts[1], nil
+}
+
+// TemplateOptions to render a job file as a go template
+type TemplateOptions struct {
+	// default to [[ and ]] like levant
+	LeftDelim  string
+	RightDelim string
+	// accessible as .name in the template
+	Values map[string]interface{}
+}
+
+// RenderTemplate renders a levant style job template, name is only used for error messages
+func RenderTemplate(name, content string, opts TemplateOptions) (string, error) {
+	left, right := opts.LeftDelim, opts.RightDelim
+	if left == "" || right == "" {
+		left, right = defaultLeftDelim, defaultRightDelim
+	}
+	values := opts.Values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+
+	funcs := templateFuncs()
+	// levant names
+	funcs["toUpper"] = funcs["upper"]
+	funcs["toLower"] = funcs["lower"]
+	funcs["loop"] = func(bounds ...int) ([]int, error) {
+		from, to := 0, 0
+		switch len(bounds) {
+		case 1:
+			to = bounds[0]
+		case 2:
+			from, to = bounds[0], bounds[1]
+		default:
+			return nil, fmt.Errorf("loop expects one or two arguments")
+		}
+		var res []int
+		for i := from; i < to; i++ {
+			res = append(res, i)
+		}
+		return res, nil
+	}
+
+	// fail on typos instead of rendering <no value>
+	tpl, err := template.New(name).Delims(left, right).Funcs(funcs).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tpl.Execute(&buf, values)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
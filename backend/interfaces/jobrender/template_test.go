@@ -0,0 +1,67 @@
+package jobrender
+
+import (
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	values, err := LoadValues([]VarFile{
+		{Name: "base.json", Content: `{"image": "nginx:1.26", "count": 1, "envs": ["a", "b"]}`},
+		{Name: "prod.yaml", Content: "envs:\n  - a\n  - c\nresources:\n  cpu: 500\n"},
+		{Name: "prod.hcl", Content: `image = "nginx:1.27"`},
+	}, map[string]interface{}{
+		"count": 3,
+	})
+	if err != nil {
+		t.Fatalf("Could not LoadValues:%v", err)
+	}
+
+	rendered, err := RenderTemplate("job.nomad", `image = "[[ .image ]]"
+count = [[ .count ]]
+[[ range $i := loop 2 ]]group "g[[ $i ]]" {}
+[[ end ]]envs = [[ .envs | toJson ]]
+cpu = [[ .resources.cpu ]]`, TemplateOptions{
+		Values: values,
+	})
+	if err != nil {
+		t.Fatalf("Could not RenderTemplate:%v", err)
+	}
+	expected := `image = "nginx:1.27"
+count = 3
+group "g0" {}
+group "g1" {}
+envs = ["a","c"]
+cpu = 500`
+	if rendered != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, rendered)
+	}
+
+	left, right, err := ParseDelims("{{ }}")
+	if err != nil {
+		t.Fatalf("Could not ParseDelims:%v", err)
+	}
+	rendered, err = RenderTemplate("job.nomad", `{{ .image | toUpper }} [[ .image ]]`, TemplateOptions{
+		LeftDelim:  left,
+		RightDelim: right,
+		Values:     values,
+	})
+	if err != nil {
+		t.Fatalf("Could not RenderTemplate:%v", err)
+	}
+	if rendered != "NGINX:1.27 [[ .image ]]" {
+		t.Fatalf("Unexpected result %s", rendered)
+	}
+
+	_, err = RenderTemplate("job.nomad", `[[ .missing ]]`, TemplateOptions{})
+	if err == nil {
+		t.Fatalf("Expected an error for a missing value")
+	}
+	_, _, err = ParseDelims("{{")
+	if err == nil {
+		t.Fatalf("Expected an error for invalid delimiters")
+	}
+	_, err = LoadValues([]VarFile{{Name: "values.yml", Content: "a: [b"}}, nil)
+	if err == nil {
+		t.Fatalf("Expected an error for invalid yaml values")
+	}
+}
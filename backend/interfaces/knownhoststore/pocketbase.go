@@ -0,0 +1,75 @@
+package knownhoststore
+
+import (
+	"context"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+type PocketBaseStore struct {
+	ctx    context.Context
+	logger log.Logger
+	cfg    PocketBaseStoreConfig
+}
+
+type PocketBaseStoreConfig struct {
+	App core.App
+}
+
+func CreatePocketBaseStore(ctx context.Context,
+	logger log.Logger,
+	cfg PocketBaseStoreConfig) (*PocketBaseStore, error) {
+	t := &PocketBaseStore{
+		ctx:    ctx,
+		logger: logger,
+		cfg:    cfg,
+	}
+
+	return t, nil
+}
+
+func (s *PocketBaseStore) ListKnownHosts(ctx context.Context) ([]*domain.KnownHost, error) {
+	records, err := s.cfg.App.Dao().FindRecordsByExpr("known_hosts")
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*domain.KnownHost
+
+	for _, record := range records {
+		res = append(res, domain.KnownHostFromRecord(record))
+	}
+	return res, nil
+}
+
+func (s *PocketBaseStore) SaveKnownHost(ctx context.Context, h *domain.KnownHost) error {
+	collection, err := s.cfg.App.Dao().FindCollectionByNameOrId("known_hosts")
+	if err != nil {
+		return err
+	}
+
+	record := models.NewRecord(collection)
+
+	form := forms.NewRecordUpsert(s.cfg.App, record)
+
+	err = form.LoadData(map[string]any{
+		"host":        h.Host,
+		"key":         h.Key,
+		"fingerprint": h.Fingerprint,
+	})
+	if err != nil {
+		return err
+	}
+
+	// validate and submit (internally it calls app.Dao().SaveRecord(record) in a transaction)
+	if err := form.Submit(); err != nil {
+		return err
+	}
+	h.ID = record.Id
+	return nil
+}
@@ -23,6 +23,7 @@ var (
 	metaKeySrcID        = "nomadopssrcid"
 	metaKeySrcUrl       = "nomadopssrcurl"
 	metaKeySrcCommit    = "nomadopssrccommit"
+	metaKeySrcSpec      = "nomadopssrcspec"
 	metaKeyForceRestart = "nomadopsforcerestart"
 )
 
@@ -66,22 +67,54 @@ func CreateClient(ctx context.Context,
 	return c, nil
 }
 
-func (c *Client) SubscribeJobChanges(ctx context.Context, cb func(jobName string)) error {
+// jobSourceID returns the id of the source that manages the job or an empty string if the job is not ours
+func jobSourceID(job *api.Job) string {
+	if job == nil || job.Meta == nil || job.Meta[metaKeyOps] != "true" {
+		return ""
+	}
+	return job.Meta[metaKeySrcID]
+}
+
+const (
+	eventStreamMinBackoff = time.Second
+	eventStreamMaxBackoff = time.Minute
+	// nomad sends heartbeats every 10 seconds
+	eventStreamHeartbeatTimeout = 35 * time.Second
+)
+
+// SubscribeJobChanges calls cb with the id of the owning source whenever a job managed by us
+// or one of its deployments changes.
+// The stream reconnects with backoff and resumes from the last seen index, onConnectionChange
+// is called whenever the stream connects or disconnects
+func (c *Client) SubscribeJobChanges(ctx context.Context, cb func(srcID string), onConnectionChange func(connected bool)) error {
 	var index uint64 = 0
 	if _, meta, err := c.client.Jobs().List(nil); err == nil {
 		index = meta.LastIndex
 	}
 
-	queryOptions := &api.QueryOptions{
-		Namespace: "*",
-	}
-
-	eventCh, err := c.client.EventStream().Stream(ctx, map[api.Topic][]string{
-		api.TopicJob:        {"*"},
-		api.TopicDeployment: {"*"},
-	}, index, queryOptions.WithContext(ctx))
+	// deployments do not carry the meta of their job, namespace/jobID => source id
+	jobSources := map[string]string{}
+	managed, err := c.ListManagedJobs(ctx)
 	if err != nil {
-		return err
+		c.logger.LogError(ctx, "Could not ListManagedJobs:%v", err)
+	}
+	for _, job := range managed {
+		jobSources[job.Namespace+"/"+job.Name] = job.SourceID
+	}
+	sourceOfJob := func(namespace, jobID string) string {
+		key := namespace + "/" + jobID
+		if srcID, ok := jobSources[key]; ok {
+			return srcID
+		}
+		job, _, err := c.client.Jobs().Info(jobID, (&api.QueryOptions{
+			Namespace: namespace,
+		}).WithContext(ctx))
+		if err != nil {
+			c.logger.LogError(ctx, "Could not get job %s in %s:%v", jobID, namespace, err)
+			return ""
+		}
+		jobSources[key] = jobSourceID(job)
+		return jobSources[key]
 	}
 
 	eventHandler := func(event *api.Events) {
@@ -94,42 +127,125 @@ func (c *Client) SubscribeJobChanges(ctx context.Context, cb func(jobName string
 
 				job, err := e.Job()
 				if err != nil {
-					return
+					c.logger.LogError(ctx, "Could not read job of '%s':%v", e.Type, err)
+					continue
 				}
 				if job == nil || job.ID == nil {
 					c.logger.LogInfo(ctx, "Received no Job on '%s': %s", e.Type, log.ToJSONString(e))
-					return
+					continue
 				}
 
-				cb(*job.ID)
+				namespace := api.DefaultNamespace
+				if job.Namespace != nil {
+					namespace = *job.Namespace
+				}
+				key := namespace + "/" + *job.ID
+				srcID := jobSourceID(job)
+				if srcID == "" {
+					// a job registered without our meta still concerns the source that owned it
+					srcID = jobSources[key]
+				}
+				if e.Type == "JobDeregistered" {
+					delete(jobSources, key)
+				} else {
+					jobSources[key] = srcID
+				}
+				if srcID == "" {
+					// not managed by us
+					continue
+				}
+				cb(srcID)
 			case "DeploymentStatusUpdate":
 				dep, err := e.Deployment()
 				if err != nil {
-					return
+					c.logger.LogError(ctx, "Could not read deployment of '%s':%v", e.Type, err)
+					continue
 				}
 				if dep == nil {
 					c.logger.LogInfo(ctx, "Received no deployment on 'DeploymentStatusUpdate': %s", log.ToJSONString(e))
-					return
+					continue
 				}
-				cb(dep.JobID)
+				srcID := sourceOfJob(dep.Namespace, dep.JobID)
+				if srcID == "" {
+					continue
+				}
+				cb(srcID)
 			default:
 			}
 		}
 	}
 
-	go func() {
+	// stream reads events until the stream fails, returns true if the stream was established
+	stream := func() bool {
+		streamCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		queryOptions := &api.QueryOptions{
+			Namespace: "*",
+		}
+		eventCh, err := c.client.EventStream().Stream(streamCtx, map[api.Topic][]string{
+			api.TopicJob:        {"*"},
+			api.TopicDeployment: {"*"},
+		}, index, queryOptions.WithContext(streamCtx))
+		if err != nil {
+			c.logger.LogError(ctx, "Could not connect to the nomad event stream:%v", err)
+			return false
+		}
+
+		c.logger.LogInfo(ctx, "Connected to the nomad event stream at index %d", index)
+		onConnectionChange(true)
+		defer onConnectionChange(false)
+
+		heartbeat := time.NewTimer(eventStreamHeartbeatTimeout)
+		defer heartbeat.Stop()
 		for {
 			select {
 			case <-ctx.Done():
-				return
+				return true
+
+			case <-heartbeat.C:
+				c.logger.LogError(ctx, "No heartbeat from the nomad event stream for %v", eventStreamHeartbeatTimeout)
+				return true
 
-			case events := <-eventCh:
+			case events, ok := <-eventCh:
+				if !ok {
+					c.logger.LogError(ctx, "Nomad event stream closed")
+					return true
+				}
+				if events.Err != nil {
+					c.logger.LogError(ctx, "Nomad event stream failed:%v", events.Err)
+					return true
+				}
+				heartbeat.Reset(eventStreamHeartbeatTimeout)
 
 				if events.IsHeartbeat() {
 					continue
 				}
 
 				eventHandler(events)
+				// resume after the last handled events
+				if events.Index >= index {
+					index = events.Index + 1
+				}
+			}
+		}
+	}
+
+	go func() {
+		backoff := eventStreamMinBackoff
+		for {
+			if stream() {
+				backoff = eventStreamMinBackoff
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			c.logger.LogInfo(ctx, "Reconnecting to the nomad event stream...")
+			backoff *= 2
+			if backoff > eventStreamMaxBackoff {
+				backoff = eventStreamMaxBackoff
 			}
 		}
 	}()
@@ -363,13 +479,17 @@ func hasUpdate(ctx context.Context,
 
 	fieldDiff := diffResp.Diff.Fields
 	if len(fieldDiff) > 0 {
-		// if only the git commit change we will not see it as a change
+		// if only the git commit or the spec hash change we will not see it as a change
 		// if only the forced restart is a change we will not see it as a change either
 		// use force to update it anyway
-		if len(fieldDiff) != 1 ||
-			(fieldDiff[0].Name != fmt.Sprintf("Meta[%s]", metaKeySrcCommit) &&
-				fieldDiff[0].Name != fmt.Sprintf("Meta[%s]", metaKeyForceRestart)) ||
-			force || restart {
+		for _, f := range fieldDiff {
+			if f.Name != fmt.Sprintf("Meta[%s]", metaKeySrcCommit) &&
+				f.Name != fmt.Sprintf("Meta[%s]", metaKeySrcSpec) &&
+				f.Name != fmt.Sprintf("Meta[%s]", metaKeyForceRestart) {
+				hasChanges = true
+			}
+		}
+		if force || restart {
 			hasChanges = true
 		}
 	}
@@ -396,8 +516,49 @@ func hasUpdate(ctx context.Context,
 	return true
 }
 
-func (c *Client) ParseJob(ctx context.Context, j string) (*application.JobInfo, error) {
-	parsedJob, err := c.client.Jobs().ParseHCL(j, false)
+// parseJSONJob parses a job in the API format, either plain or wrapped as {"Job": ...} like `nomad job run -output` renders it
+func parseJSONJob(j string) (*api.Job, error) {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal([]byte(j), &fields)
+	if err != nil {
+		return nil, err
+	}
+	data := []byte(j)
+	if wrapped, ok := fields["Job"]; ok {
+		data = wrapped
+	}
+
+	job := &api.Job{}
+	err = json.Unmarshal(data, job)
+	if err != nil {
+		return nil, err
+	}
+	if job.Name == nil || *job.Name == "" {
+		// nomad defaults the name to the id
+		job.Name = job.ID
+	}
+	if job.Name == nil || *job.Name == "" {
+		return nil, fmt.Errorf("job has neither a name nor an id")
+	}
+	return job, nil
+}
+
+func (c *Client) ParseJob(ctx context.Context, j string, opts application.ParseJobOptions) (*application.JobInfo, error) {
+	if opts.Format == application.JobFormatJSON {
+		parsedJob, err := parseJSONJob(j)
+		if err != nil {
+			c.logger.LogError(ctx, "could not parse json job: %v", err)
+			return nil, err
+		}
+		return &application.JobInfo{
+			Job: parsedJob,
+		}, nil
+	}
+
+	parsedJob, err := c.client.Jobs().ParseHCLOpts(&api.JobsParseRequest{
+		JobHCL:    j,
+		Variables: opts.Variables,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -452,9 +613,26 @@ func (c *Client) getWriteOptions(ctx context.Context, src *domain.Source, job *a
 func (c *Client) UpdateJob(ctx context.Context,
 	src *domain.Source,
 	job *application.JobInfo,
-	restart bool) (*application.UpdateJobInfo, error) {
+	opts application.UpdateJobOptions) (*application.UpdateJobInfo, error) {
+
+	restart := opts.Restart
 
-	if src.CreateNamespace {
+	if src.CreateNamespace && opts.DryRun {
+		namespace := c.getWriteOptions(ctx, src, job).Namespace
+		if namespace == "" {
+			return nil, fmt.Errorf("require a namespace to be set in conjunction with 'CreateNamespace'")
+		}
+		_, _, err := c.client.Namespaces().Info(namespace, c.getQueryOptsCtx(ctx, src, job))
+		if err != nil {
+			if !strings.Contains(strings.ToLower(err.Error()), "not found") {
+				c.logger.LogError(ctx, "could not get namespace %s: %v", namespace, err)
+				return nil, err
+			}
+			// the namespace would be created, nomad cannot plan jobs in it before
+			return &application.UpdateJobInfo{Created: true}, nil
+		}
+	}
+	if src.CreateNamespace && !opts.DryRun {
 		writeOptions := c.getWriteOptions(ctx, src, job)
 		if writeOptions.Namespace == "" {
 			return nil, fmt.Errorf("require a namespace to be set in conjunction with 'CreateNamespace'")
@@ -482,6 +660,9 @@ func (c *Client) UpdateJob(ctx context.Context,
 	metadata[metaKeySrcUrl] = src.URL
 	metadata[metaKeySrcID] = src.ID
 	metadata[metaKeySrcCommit] = job.GitInfo.GitCommit
+	if job.SpecHash != "" {
+		metadata[metaKeySrcSpec] = job.SpecHash
+	}
 
 	if restart {
 		metadata[metaKeyForceRestart] = time.Now().Format(time.RFC3339Nano)
@@ -525,7 +706,8 @@ func (c *Client) UpdateJob(ctx context.Context,
 
 	c.logger.LogTrace(ctx, "Job Diff:%v", log.ToJSONString(resp.Diff))
 
-	if !src.Paused {
+	evalID := ""
+	if !opts.DryRun {
 		regResp, _, err := c.client.Jobs().Register(job.Job, c.getWriteOptions(ctx, src, job))
 		if err != nil {
 			c.logger.LogError(ctx, "could not register job %s: %v", *job.Job.Name, err)
@@ -533,20 +715,119 @@ func (c *Client) UpdateJob(ctx context.Context,
 		}
 
 		c.logger.LogInfo(ctx, "Job Post:%v", log.ToJSONString(regResp))
+		evalID = regResp.EvalID
+		// the latest deployment belongs to the replaced version
+		deploymentStatus = ""
+	}
+
+	// the plan of a job that does not exist yet adds it
+	created := resp.Diff != nil && resp.Diff.Type == "Added"
+
+	var placementFailures json.RawMessage
+	if len(resp.FailedTGAllocs) > 0 {
+		placementFailures = json.RawMessage(log.ToJSONString(resp.FailedTGAllocs))
 	}
 
 	return &application.UpdateJobInfo{
-		Updated: true, // TODO check for creation, for now everything is an update...which is kinda true
-		Diff:    json.RawMessage(log.ToJSONString(resp.Diff)),
+		Created:           created,
+		Updated:           !created,
+		Diff:              json.RawMessage(log.ToJSONString(resp.Diff)),
+		PlacementFailures: placementFailures,
 		DeploymentStatus: application.DeploymentStatus{
 			Status: deploymentStatus,
 		},
+		EvalID: evalID,
 	}, nil
 }
 
-func (c *Client) DeleteJob(ctx context.Context, src *domain.Source, job *application.JobInfo) error {
+func (c *Client) WaitForDeployment(ctx context.Context,
+	src *domain.Source,
+	job *application.JobInfo,
+	evalID string) (*application.DeploymentStatus, error) {
+
+	queryOptions := c.getQueryOptsCtx(ctx, src, job)
+
+	// blocking queries return as soon as the evaluation or deployment changes
+	deploymentID := ""
+	for deploymentID == "" {
+		eval, meta, err := c.client.Evaluations().Info(evalID, queryOptions)
+		if err != nil {
+			return nil, err
+		}
+		switch eval.Status {
+		case api.EvalStatusComplete:
+			if eval.DeploymentID == "" {
+				// e.g. batch jobs or jobs without an update block
+				return &application.DeploymentStatus{}, nil
+			}
+			deploymentID = eval.DeploymentID
+		case api.EvalStatusFailed, api.EvalStatusCancelled:
+			return nil, fmt.Errorf("evaluation %s %s: %s", evalID, eval.Status, eval.StatusDescription)
+		}
+		queryOptions.WaitIndex = meta.LastIndex
+	}
+
+	queryOptions.WaitIndex = 0
+	for {
+		deployment, meta, err := c.client.Deployments().Info(deploymentID, queryOptions)
+		if err != nil {
+			return nil, err
+		}
+		switch deployment.Status {
+		case api.DeploymentStatusSuccessful, api.DeploymentStatusFailed, api.DeploymentStatusCancelled:
+			return &application.DeploymentStatus{
+				Status:      deployment.Status,
+				Description: deployment.StatusDescription,
+			}, nil
+		}
+		c.logger.LogTrace(ctx, "Deployment %s of %s is %s", deploymentID, *job.ID, deployment.Status)
+		queryOptions.WaitIndex = meta.LastIndex
+	}
+}
+
+func (c *Client) RevertJob(ctx context.Context, src *domain.Source, job *application.JobInfo) (uint64, error) {
+	versions, _, _, err := c.client.Jobs().Versions(*job.ID, false, c.getQueryOptsCtx(ctx, src, job))
+	if err != nil {
+		return 0, err
+	}
+
+	var current *api.Job
+	for _, v := range versions {
+		if current == nil || *v.Version > *current.Version {
+			current = v
+		}
+	}
+	if current == nil {
+		return 0, fmt.Errorf("job %s has no versions", *job.ID)
+	}
 
-	_, _, err := c.client.Jobs().Deregister(*job.Job.Name, false, c.getWriteOptions(ctx, src, job))
+	var stable *api.Job
+	for _, v := range versions {
+		if v.Stable == nil || !*v.Stable || *v.Version >= *current.Version {
+			continue
+		}
+		if stable == nil || *v.Version > *stable.Version {
+			stable = v
+		}
+	}
+	if stable == nil {
+		return 0, fmt.Errorf("job %s has no stable version to roll back to", *job.ID)
+	}
+
+	_, _, err = c.client.Jobs().Revert(*job.ID, *stable.Version, current.Version,
+		c.getWriteOptions(ctx, src, job), "", "")
+	if err != nil {
+		return 0, err
+	}
+	return *stable.Version, nil
+}
+
+func (c *Client) DeleteJob(ctx context.Context,
+	src *domain.Source,
+	job *application.JobInfo,
+	opts application.DeleteJobOptions) error {
+
+	_, _, err := c.client.Jobs().Deregister(*job.Job.Name, opts.Purge, c.getWriteOptions(ctx, src, job))
 
 	if err != nil {
 		return err
@@ -555,10 +836,100 @@ func (c *Client) DeleteJob(ctx context.Context, src *domain.Source, job *applica
 	return nil
 }
 
+func (c *Client) DeleteNamespaceIfEmpty(ctx context.Context, src *domain.Source, namespace string) (bool, error) {
+	queryOptions := (&api.QueryOptions{
+		Namespace: namespace,
+		Region:    src.Region,
+	}).WithContext(ctx)
+
+	ns, _, err := c.client.Namespaces().Info(namespace, queryOptions)
+	if err != nil {
+		return false, err
+	}
+	if ns.Meta[metaKeyOps] != "true" {
+		// not created by us
+		return false, nil
+	}
+
+	jobs, _, err := c.client.Jobs().List(queryOptions)
+	if err != nil {
+		return false, err
+	}
+	for _, j := range jobs {
+		if !j.Stop && j.Status != "dead" {
+			return false, nil
+		}
+	}
+
+	_, err = c.client.Namespaces().Delete(namespace, (&api.WriteOptions{
+		Region: src.Region,
+	}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (c *Client) GetURL(ctx context.Context) (string, error) {
 	return c.url, nil
 }
 
+func (c *Client) GetJob(ctx context.Context, src *domain.Source, job *application.JobInfo) (*application.JobInfo, error) {
+	queryOptions := c.getQueryOptsCtx(ctx, src, job)
+	queryOptions.Prefix = *job.ID
+
+	stubs, _, err := c.client.Jobs().List(queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, stub := range stubs {
+		if stub.ID != *job.ID {
+			continue
+		}
+		j, _, err := c.client.Jobs().Info(stub.ID, c.getQueryOptsCtx(ctx, src, job))
+		if err != nil {
+			return nil, err
+		}
+		return &application.JobInfo{
+			GitInfo: application.GitInfo{
+				GitCommit: j.Meta[metaKeySrcCommit],
+			},
+			SpecHash: j.Meta[metaKeySrcSpec],
+			Job:      j,
+		}, nil
+	}
+	return nil, application.ErrNotFound
+}
+
+func (c *Client) ListManagedJobs(ctx context.Context) ([]*application.ManagedJob, error) {
+	queryOptions := &api.QueryOptions{
+		Namespace: "*", // Query all authorized namespaces
+		Params: map[string]string{
+			"meta": "true",
+		},
+		Filter: fmt.Sprintf(`"%s" in Meta and Meta["%s"] == "true"`, metaKeyOps, metaKeyOps),
+	}
+	joblist, _, err := c.client.Jobs().List(queryOptions.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*application.ManagedJob
+	for _, job := range joblist {
+		if job.ParentID != "" {
+			// dispatched or periodic child
+			continue
+		}
+		res = append(res, &application.ManagedJob{
+			Name:      job.Name,
+			Namespace: job.Namespace,
+			SourceID:  job.Meta[metaKeySrcID],
+			SourceURL: job.Meta[metaKeySrcUrl],
+		})
+	}
+	return res, nil
+}
+
 func (c *Client) GetCurrentClusterState(ctx context.Context,
 	opts application.GetCurrentClusterStateOptions) (*application.ClusterState, error) {
 
@@ -599,7 +970,11 @@ func (c *Client) GetCurrentClusterState(ctx context.Context,
 		}
 
 		clusterState.CurrentJobs[job.Name] = &application.JobInfo{
-			Job: j,
+			GitInfo: application.GitInfo{
+				GitCommit: m[metaKeySrcCommit],
+			},
+			SpecHash: m[metaKeySrcSpec],
+			Job:      j,
 		}
 	}
 
@@ -40,7 +40,7 @@ func Test_IgnoreAutoscalingOptions_Horizontal(t *testing.T) {
 		t.Fatalf("Error reading job file: %v", err)
 	}
 
-	jobInfo, err := nomadClient.ParseJob(ctx, string(b))
+	jobInfo, err := nomadClient.ParseJob(ctx, string(b), application.ParseJobOptions{})
 	if err != nil {
 		t.Fatalf("Error parsing job: %v", err)
 	}
@@ -51,19 +51,19 @@ func Test_IgnoreAutoscalingOptions_Horizontal(t *testing.T) {
 	// Prepare environment
 
 	// deploy job
-	scaledJob, err := nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	scaledJob, err := nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
-	if !scaledJob.Updated {
-		t.Fatalf("Job not updated")
+	if !scaledJob.Updated && !scaledJob.Created {
+		t.Fatalf("Job not deployed")
 	}
 
 	// we now have a job with count 2 running
 	// now we will update the job with count 1 => it should be a no-op
 	count = 1
 	jobInfo.Job.TaskGroups[0].Count = &count
-	info, err := nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	info, err := nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
@@ -76,7 +76,7 @@ func Test_IgnoreAutoscalingOptions_Horizontal(t *testing.T) {
 	jobInfo.Job.TaskGroups[0].Tasks[0].Env = map[string]string{
 		"TEST": "123",
 	}
-	info, err = nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	info, err = nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
@@ -121,7 +121,7 @@ func Test_IgnoreAutoscalingOptions_Vertical(t *testing.T) {
 		t.Fatalf("Error reading job file: %v", err)
 	}
 
-	jobInfo, err := nomadClient.ParseJob(ctx, string(b))
+	jobInfo, err := nomadClient.ParseJob(ctx, string(b), application.ParseJobOptions{})
 	if err != nil {
 		t.Fatalf("Error parsing job: %v", err)
 	}
@@ -132,19 +132,19 @@ func Test_IgnoreAutoscalingOptions_Vertical(t *testing.T) {
 	// Prepare environment
 
 	// deploy job
-	scaledJob, err := nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	scaledJob, err := nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
-	if !scaledJob.Updated {
-		t.Fatalf("Job not updated")
+	if !scaledJob.Updated && !scaledJob.Created {
+		t.Fatalf("Job not deployed")
 	}
 
 	// we now have a task with 600 CPU running
 	// now we will update the job with CPU 500 => it should be a no-op
 	cpu = 500
 	jobInfo.Job.TaskGroups[0].Tasks[0].Resources.CPU = &cpu
-	info, err := nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	info, err := nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
@@ -157,7 +157,7 @@ func Test_IgnoreAutoscalingOptions_Vertical(t *testing.T) {
 	jobInfo.Job.TaskGroups[0].Tasks[0].Env = map[string]string{
 		"TEST": "123",
 	}
-	info, err = nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	info, err = nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
@@ -202,7 +202,7 @@ func Test_UpdateJob(t *testing.T) {
 		t.Fatalf("Error reading job file: %v", err)
 	}
 
-	jobInfo, err := nomadClient.ParseJob(ctx, string(b))
+	jobInfo, err := nomadClient.ParseJob(ctx, string(b), application.ParseJobOptions{})
 	if err != nil {
 		t.Fatalf("Error parsing job: %v", err)
 	}
@@ -211,17 +211,17 @@ func Test_UpdateJob(t *testing.T) {
 	}
 
 	// deploy job
-	scaledJob, err := nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	scaledJob, err := nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
-	if !scaledJob.Updated {
-		t.Fatalf("Job not updated")
+	if !scaledJob.Updated && !scaledJob.Created {
+		t.Fatalf("Job not deployed")
 	}
 
 	// deploy job again with different commit
 	jobInfo.GitInfo.GitCommit = "654321"
-	scaledJob, err = nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	scaledJob, err = nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
@@ -232,7 +232,7 @@ func Test_UpdateJob(t *testing.T) {
 	// deploy job again with different commit, but with force set
 	jobInfo.GitInfo.GitCommit = "654321"
 	source.Force = true // forces every commit to be an update
-	scaledJob, err = nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	scaledJob, err = nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
@@ -242,7 +242,7 @@ func Test_UpdateJob(t *testing.T) {
 	source.Force = false
 
 	// deploy job again but force restart
-	scaledJob, err = nomadClient.UpdateJob(ctx, source, jobInfo, true)
+	scaledJob, err = nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{Restart: true})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
@@ -255,7 +255,7 @@ func Test_UpdateJob(t *testing.T) {
 	jobInfo.Job.TaskGroups[0].Tasks[0].Env = map[string]string{
 		"TEST": "123",
 	}
-	scaledJob, err = nomadClient.UpdateJob(ctx, source, jobInfo, false)
+	scaledJob, err = nomadClient.UpdateJob(ctx, source, jobInfo, application.UpdateJobOptions{})
 	if err != nil {
 		t.Fatalf("Error updating job: %v", err)
 	}
@@ -263,3 +263,31 @@ func Test_UpdateJob(t *testing.T) {
 		t.Fatalf("Job should have been updated as the env changed")
 	}
 }
+
+func Test_ParseJSONJob(t *testing.T) {
+	b, err := os.ReadFile("testdata/nginx.json")
+	if err != nil {
+		t.Fatalf("Error reading job file: %v", err)
+	}
+
+	job, err := parseJSONJob(string(b))
+	if err != nil {
+		t.Fatalf("Error parsing wrapped job: %v", err)
+	}
+	if *job.Name != "nginx" || len(job.TaskGroups) != 1 || job.TaskGroups[0].Tasks[0].Config["image"] != "nginx:1.27" {
+		t.Fatalf("Unexpected job: %v", log.ToJSONString(job))
+	}
+
+	job, err = parseJSONJob(`{"ID": "plain", "Type": "batch"}`)
+	if err != nil {
+		t.Fatalf("Error parsing plain job: %v", err)
+	}
+	if *job.Name != "plain" || *job.Type != "batch" {
+		t.Fatalf("Unexpected job: %v", log.ToJSONString(job))
+	}
+
+	_, err = parseJSONJob(`{"Type": "batch"}`)
+	if err == nil {
+		t.Fatalf("Expected an error for a job without name")
+	}
+}
@@ -0,0 +1,117 @@
+package syncrunstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/models"
+	"github.com/pocketbase/pocketbase/tools/types"
+
+	"github.com/nomad-ops/nomad-ops/backend/application"
+	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/log"
+)
+
+type PocketBaseStore struct {
+	ctx    context.Context
+	logger log.Logger
+	cfg    PocketBaseStoreConfig
+}
+
+type PocketBaseStoreConfig struct {
+	App core.App
+}
+
+func CreatePocketBaseStore(ctx context.Context,
+	logger log.Logger,
+	cfg PocketBaseStoreConfig) (*PocketBaseStore, error) {
+	t := &PocketBaseStore{
+		ctx:    ctx,
+		logger: logger,
+		cfg:    cfg,
+	}
+
+	return t, nil
+}
+
+func (s *PocketBaseStore) SaveSyncRun(ctx context.Context, run *domain.SyncRun) error {
+	collection, err := s.cfg.App.Dao().FindCollectionByNameOrId("sync_runs")
+	if err != nil {
+		return err
+	}
+
+	record := models.NewRecord(collection)
+
+	form := forms.NewRecordUpsert(s.cfg.App, record)
+
+	err = form.LoadData(map[string]any{
+		"source":     run.SourceID,
+		"startedAt":  run.StartedAt,
+		"finishedAt": run.FinishedAt,
+		"gitCommit":  run.GitCommit,
+		"gitRef":     run.GitRef,
+		"trigger":    string(run.Trigger),
+		"dryRun":     run.DryRun,
+		"result":     run.Result,
+		"message":    run.Message,
+		"error":      run.Error,
+		"jobs":       run.Jobs,
+	})
+	if err != nil {
+		return err
+	}
+
+	// validate and submit (internally it calls app.Dao().SaveRecord(record) in a transaction)
+	if err := form.Submit(); err != nil {
+		return err
+	}
+	run.ID = record.Id
+	return nil
+}
+
+// ListSyncRuns returns the newest runs first
+func (s *PocketBaseStore) ListSyncRuns(ctx context.Context, opts application.ListSyncRunsOptions) ([]*domain.SyncRun, error) {
+	filter := "id != ''"
+	params := dbx.Params{}
+	if opts.SourceID != "" {
+		filter += " && source = {:source}"
+		params["source"] = opts.SourceID
+	}
+	// runs that were still running at Since or already started at Until
+	if !opts.Since.IsZero() {
+		filter += " && finishedAt >= {:since}"
+		params["since"] = opts.Since.UTC().Format(types.DefaultDateLayout)
+	}
+	if !opts.Until.IsZero() {
+		filter += " && startedAt <= {:until}"
+		params["until"] = opts.Until.UTC().Format(types.DefaultDateLayout)
+	}
+
+	records, err := s.cfg.App.Dao().FindRecordsByFilter("sync_runs", filter, "-startedAt", opts.Limit, 0, params)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*domain.SyncRun, 0, len(records))
+	for _, record := range records {
+		res = append(res, domain.SyncRunFromRecord(record))
+	}
+	return res, nil
+}
+
+// DeleteSyncRunsBefore deletes the runs that finished before the given time
+func (s *PocketBaseStore) DeleteSyncRunsBefore(ctx context.Context, t time.Time) (int64, error) {
+	collection, err := s.cfg.App.Dao().FindCollectionByNameOrId("sync_runs")
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.cfg.App.Dao().DB().Delete(collection.Name, dbx.NewExp("finishedAt < {:before}", dbx.Params{
+		"before": t.UTC().Format(types.DefaultDateLayout),
+	})).Execute()
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -2,6 +2,7 @@ package teamstore
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
@@ -9,6 +10,7 @@ import (
 	"github.com/pocketbase/pocketbase/models"
 
 	"github.com/nomad-ops/nomad-ops/backend/domain"
+	"github.com/nomad-ops/nomad-ops/backend/utils/errors"
 	"github.com/nomad-ops/nomad-ops/backend/utils/log"
 )
 
@@ -34,6 +36,17 @@ func CreatePocketBaseStore(ctx context.Context,
 	return t, nil
 }
 
+func (s *PocketBaseStore) GetTeam(ctx context.Context, id string) (*domain.Team, error) {
+	record, err := s.cfg.App.Dao().FindRecordById("teams", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrNotFound
+		}
+		return nil, err
+	}
+	return domain.TeamFromRecord(record), nil
+}
+
 func (s *PocketBaseStore) UpsertTeam(ctx context.Context, t *domain.Team) error {
 	err := s.cfg.App.Dao().RunInTransaction(func(txDao *daos.Dao) error {
 		records, err := txDao.FindRecordsByExpr("teams",
@@ -0,0 +1,72 @@
+package debounce
+
+import (
+	"sync"
+	"time"
+)
+
+type pending struct {
+	timer *time.Timer
+	first time.Time
+}
+
+// Debouncer collapses bursts of triggers per key into a single call
+type Debouncer struct {
+	lock     sync.Mutex
+	delay    time.Duration
+	maxDelay time.Duration
+	fn       func(key string)
+	pending  map[string]*pending
+}
+
+// New calls fn once no trigger for a key happened for delay,
+// but at the latest maxDelay after the first trigger of a burst
+func New(delay, maxDelay time.Duration, fn func(key string)) *Debouncer {
+	if maxDelay < delay {
+		maxDelay = delay
+	}
+	return &Debouncer{
+		delay:    delay,
+		maxDelay: maxDelay,
+		fn:       fn,
+		pending:  map[string]*pending{},
+	}
+}
+
+// Trigger schedules a call for the key
+func (d *Debouncer) Trigger(key string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if p, ok := d.pending[key]; ok {
+		wait := d.delay
+		if remaining := d.maxDelay - time.Since(p.first); remaining < wait {
+			wait = remaining
+		}
+		if p.timer.Reset(wait) {
+			return
+		}
+		// the timer already fired and its call waits for the lock,
+		// the trigger starts a new burst that the stale call skips
+	}
+
+	p := &pending{
+		first: time.Now(),
+	}
+	p.timer = time.AfterFunc(d.delay, func() {
+		d.fire(key, p)
+	})
+	d.pending[key] = p
+}
+
+// fire calls fn for the burst p unless a newer burst replaced it
+func (d *Debouncer) fire(key string, p *pending) {
+	d.lock.Lock()
+	if d.pending[key] != p {
+		d.lock.Unlock()
+		return
+	}
+	delete(d.pending, key)
+	d.lock.Unlock()
+	d.fn(key)
+}
@@ -0,0 +1,95 @@
+package debounce
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type counter struct {
+	lock  sync.Mutex
+	calls map[string]int
+}
+
+func (c *counter) call(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.calls[key]++
+}
+
+func (c *counter) get(key string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.calls[key]
+}
+
+// waitFor polls until cond holds, the deadline is generous to not depend on the scheduler
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	c := &counter{calls: map[string]int{}}
+	d := New(200*time.Millisecond, 5*time.Second, c.call)
+
+	// the burst is far shorter than the delay
+	for i := 0; i < 5; i++ {
+		d.Trigger("a")
+		d.Trigger("b")
+	}
+	waitFor(t, func() bool {
+		return c.get("a") == 1 && c.get("b") == 1
+	})
+	time.Sleep(400 * time.Millisecond)
+	if c.get("a") != 1 || c.get("b") != 1 {
+		t.Fatalf("Expected one call per key, got %d and %d", c.get("a"), c.get("b"))
+	}
+}
+
+func TestDebouncerMaxDelay(t *testing.T) {
+	c := &counter{calls: map[string]int{}}
+	d := New(200*time.Millisecond, 400*time.Millisecond, c.call)
+
+	// a continuous burst is flushed after maxDelay
+	stop := time.Now().Add(5 * time.Second)
+	for c.get("c") == 0 && time.Now().Before(stop) {
+		d.Trigger("c")
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.get("c") == 0 {
+		t.Fatalf("Expected a call during a continuous burst")
+	}
+}
+
+func TestDebouncerStaleFire(t *testing.T) {
+	c := &counter{calls: map[string]int{}}
+	d := New(time.Hour, time.Hour, c.call)
+
+	d.Trigger("a")
+	stale := d.pending["a"]
+	// simulates a timer that fired but whose call did not get the lock yet
+	stale.timer.Stop()
+	d.Trigger("a")
+
+	current := d.pending["a"]
+	if current == stale {
+		t.Fatalf("Expected the trigger to start a new burst")
+	}
+	d.fire("a", stale)
+	if c.get("a") != 0 || d.pending["a"] != current {
+		t.Fatalf("Expected the stale call to be skipped, got %d calls", c.get("a"))
+	}
+
+	d.fire("a", current)
+	if c.get("a") != 1 || len(d.pending) != 0 {
+		t.Fatalf("Expected one call for the new burst, got %d calls", c.get("a"))
+	}
+	current.timer.Stop()
+}
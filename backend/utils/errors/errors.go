@@ -21,6 +21,8 @@ var (
 	ErrReceiverError = errors.New("receiver made an error")
 	// ErrInvalid ...
 	ErrInvalid = errors.New("invalid")
+	// ErrUnauthorized ...
+	ErrUnauthorized = errors.New("unauthorized")
 )
 
 // TemporaryError ...
@@ -4,18 +4,37 @@ export interface Source {
     name: string,
     url: string,
     path: string,
+    type?: "jobs" | "pack",
+    template?: boolean,
+    templateDelims?: string,
+    valuesFiles?: string[],
+    values?: {[name: string]: any},
+    include?: string[],
+    exclude?: string[],
+    variables?: {[name: string]: string},
+    varFiles?: string[],
     id?: string,
     branch: string,
+    revisionKind?: "branch" | "tag" | "semver" | "commit",
     dataCenter: string,
     namespace?: string,
     region?: string,
     force?: boolean,
     paused?: boolean,
+    waitForDeployment?: boolean,
+    autoRollback?: boolean,
+    requireApproval?: boolean,
+    selfHeal?: "enabled" | "disabled",
+    prune?: "auto" | "confirm" | "never",
+    deletionPolicy?: "orphan" | "deregister" | "purge",
+    syncWindows?: SyncWindow[],
     created?: string,
     updated?: string,
     teams?: string[],
     deployKey?: string | string[],
+    credential?: string | string[],
     vaultToken?: string | string[],
+    webhookSecret?: string | string[],
     status?: SourceStatus | null
 }
 
@@ -23,9 +42,28 @@ export interface SourceStatus {
     jobs?: {[jobID: string]: any}
     status: string,
     message?: string,
+    pendingPrune?: string[],
+    rolledBackCommit?: string,
+    approvedCommit?: string,
+    pendingApprovalCommit?: string,
+    rejectedCommit?: string,
+    pinnedCommit?: string,
+    pinnedBy?: string,
+    pendingChanges?: {
+        create?: string[],
+        update?: string[],
+        delete?: string[]
+    },
     lastCheckTime?: string
 }
 
+export interface SyncWindow {
+    kind: "allow" | "deny",
+    schedule: string,
+    duration: string,
+    timeZone?: string
+}
+
 export function userIsSourceMember(src: Source, teams: Team[], userID: string) : boolean {
     if (src.teams === undefined) {
         return true; // nobody owns this source => everybody is considered part of this source
@@ -1,8 +1,11 @@
 
+import { SyncWindow } from "./Source";
+
 export interface Team {
     id?: string,
     name: string,
     members?: string[],
+    syncWindows?: SyncWindow[],
     created?: string
 }
 
@@ -0,0 +1,7 @@
+export interface WebhookSecret {
+    id?: string
+    name: string,
+    secret: string,
+    created?: string,
+    team?: string,
+}
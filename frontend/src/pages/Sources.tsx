@@ -19,6 +19,10 @@ import QuestionMarkIcon from '@mui/icons-material/QuestionMark';
 import PauseIcon from '@mui/icons-material/Pause';
 import InfoIcon from '@mui/icons-material/Info';
 import PublishedWithChangesIcon from '@mui/icons-material/PublishedWithChanges';
+import ThumbUpIcon from '@mui/icons-material/ThumbUp';
+import ThumbDownIcon from '@mui/icons-material/ThumbDown';
+import PushPinIcon from '@mui/icons-material/PushPin';
+import PushPinOutlinedIcon from '@mui/icons-material/PushPinOutlined';
 import { useForm } from "react-hook-form";
 import SourceService from '../services/SourceService';
 import NotificationService from '../services/NotificationService';
@@ -383,6 +387,7 @@ export default function Sources() {
                         </Avatar>;
                         break;
                     case "syncedwitherror":
+                    case "rolledback":
                         avatar = <Avatar sx={{ bgcolor: orange[500] }} aria-label="recipe">
                             <ErrorIcon />
                         </Avatar>;
@@ -393,11 +398,15 @@ export default function Sources() {
                         </Avatar>;
                         break;
                     case "syncing":
+                    case "progressing":
                         avatar = <Avatar sx={{ bgcolor: teal[500] }} aria-label="recipe">
                             <LoopIcon />
                         </Avatar>;
                         break;
                     case "outofsync":
+                    case "drifted":
+                    case "blocked-by-window":
+                    case "pending-approval":
                         avatar = <Avatar sx={{ bgcolor: orange[500] }} aria-label="recipe">
                             <PublishedWithChangesIcon />
                         </Avatar>;
@@ -723,6 +732,66 @@ export default function Sources() {
                                 </IconButton>
                             </Tooltip>
 
+                            {k.status?.pendingApprovalCommit ? <Tooltip title="Approve">
+                                <IconButton aria-label="approve" color='primary' onClick={() => {
+                                    if (!k.id || !k.status?.pendingApprovalCommit) {
+                                        return;
+                                    }
+                                    SourceService.approveSource(k.id, k.status.pendingApprovalCommit)
+                                        .then(() => {
+                                            NotificationService.notifySuccess(`Approved commit ${k.status?.pendingApprovalCommit} of ${k.url} ...`);
+                                        });
+                                }}>
+                                    <ThumbUpIcon />
+                                </IconButton>
+                            </Tooltip> : undefined}
+                            {k.status?.pendingApprovalCommit ? <Tooltip title="Reject">
+                                <IconButton aria-label="reject" color='primary' onClick={() => {
+                                    if (!k.id || !k.status?.pendingApprovalCommit) {
+                                        return;
+                                    }
+                                    if (window.confirm(`Do you really want to reject commit ${k.status.pendingApprovalCommit}?`) !== true) {
+                                        return;
+                                    }
+                                    SourceService.approveSource(k.id, k.status.pendingApprovalCommit, true)
+                                        .then(() => {
+                                            NotificationService.notifySuccess(`Rejected commit ${k.status?.pendingApprovalCommit} of ${k.url} ...`);
+                                        });
+                                }}>
+                                    <ThumbDownIcon />
+                                </IconButton>
+                            </Tooltip> : undefined}
+
+                            {!k.status?.pinnedCommit ? <Tooltip title="Pin to commit">
+                                <IconButton aria-label="pin" color='primary' onClick={() => {
+                                    if (!k.id) {
+                                        return;
+                                    }
+                                    const commit = window.prompt(`Pin ${k.name} to commit (full sha):`);
+                                    if (!commit) {
+                                        return;
+                                    }
+                                    SourceService.pinSource(k.id, commit.trim())
+                                        .then(() => {
+                                            NotificationService.notifySuccess(`Pinning ${k.url} to ${commit} ...`);
+                                        });
+                                }}>
+                                    <PushPinIcon />
+                                </IconButton>
+                            </Tooltip> : <Tooltip title={`Unpin from ${k.status.pinnedCommit}`}>
+                                <IconButton aria-label="unpin" color='primary' onClick={() => {
+                                    if (!k.id) {
+                                        return;
+                                    }
+                                    SourceService.unpinSource(k.id)
+                                        .then(() => {
+                                            NotificationService.notifySuccess(`Unpinning ${k.url} ...`);
+                                        });
+                                }}>
+                                    <PushPinOutlinedIcon />
+                                </IconButton>
+                            </Tooltip>}
+
                             {k.paused !== true ? <Tooltip title="Pause">
                                 <IconButton aria-label="pause" color='primary' onClick={() => {
                                     if (!k.id) {
@@ -754,7 +823,14 @@ export default function Sources() {
                                     if (!k.id) {
                                         return;
                                     }
-                                    SourceService.syncSource(k.id)
+                                    let overrideWindow = false;
+                                    if (k.status?.status === "blocked-by-window") {
+                                        if (window.confirm(`${k.name} is outside of its sync windows. Do you really want to sync it anyway?`) !== true) {
+                                            return;
+                                        }
+                                        overrideWindow = true;
+                                    }
+                                    SourceService.syncSource(k.id, overrideWindow)
                                         .then(() => {
                                             NotificationService.notifySuccess(`Syncing ${k.url} ...`);
                                         });
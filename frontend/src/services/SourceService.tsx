@@ -17,8 +17,36 @@ const SourceService = {
             teams: teams
         });
     },
-    syncSource: (id: string) => {
+    syncSource: (id: string, overrideWindow?: boolean) => {
         return pb.send("/api/actions/sources/sync", {
+            method: "POST",
+            params: {
+                id: id,
+                overrideWindow: overrideWindow === true ? "true" : undefined
+            }
+        });
+    },
+    approveSource: (id: string, commit: string, reject?: boolean) => {
+        return pb.send("/api/actions/sources/approve", {
+            method: "POST",
+            params: {
+                id: id,
+                commit: commit,
+                reject: reject === true ? "true" : undefined
+            }
+        });
+    },
+    pinSource: (id: string, commit: string) => {
+        return pb.send("/api/actions/sources/pin", {
+            method: "POST",
+            params: {
+                id: id,
+                commit: commit
+            }
+        });
+    },
+    unpinSource: (id: string) => {
+        return pb.send("/api/actions/sources/unpin", {
             method: "POST",
             params: {
                 id: id